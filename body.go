@@ -0,0 +1,126 @@
+package wiregock
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/antchfx/xmlquery"
+)
+
+// requestBody parses the body of a request at most once per representation,
+// however many rules of however many stubs look at it.
+type requestBody struct {
+	raw string
+
+	bytesOnce sync.Once
+	data      []byte
+
+	jsonOnce  sync.Once
+	jsonValue interface{}
+	jsonErr   error
+
+	jsonPathOnce  sync.Once
+	jsonPathValue interface{}
+	jsonPathErr   error
+
+	xmlNodeOnce sync.Once
+	xmlNode     *xmlquery.Node
+	xmlNodeErr  error
+
+	formOnce   sync.Once
+	formValues url.Values
+
+	partsOnce        sync.Once
+	partsContentType string
+	parts            []FileFormData
+
+	xmlElementOnce sync.Once
+	xmlElement     *xmlElement
+	xmlElementErr  error
+}
+
+// bodyRule is implemented by rules which can reuse the parsed body instead
+// of parsing the raw string again.
+type bodyRule interface {
+	checkBody(body *requestBody) (bool, error)
+}
+
+func newRequestBody(raw string) *requestBody {
+	return &requestBody{raw: raw}
+}
+
+// newRequestBodyBytes copies the data, as the caller may reuse its buffer
+// for the next request.
+func newRequestBodyBytes(data []byte) *requestBody {
+	return &requestBody{raw: string(data)}
+}
+
+// bytes is the body as it was read, for the matchers of binary bodies.
+func (body *requestBody) bytes() []byte {
+	body.bytesOnce.Do(func() {
+		if body.data == nil {
+			body.data = []byte(body.raw)
+		}
+	})
+	return body.data
+}
+
+func checkBody(rule Rule, body *requestBody) (bool, error) {
+	if bodyRule, ok := rule.(bodyRule); ok {
+		return bodyRule.checkBody(body)
+	}
+	return rule.Check(body.raw)
+}
+
+// json decodes numbers as json.Number, the way equalToJson and JSON schemas
+// compare them.
+func (body *requestBody) json() (interface{}, error) {
+	body.jsonOnce.Do(func() {
+		body.jsonValue, body.jsonErr = parseJson(body.raw)
+	})
+	return body.jsonValue, body.jsonErr
+}
+
+// jsonPath decodes numbers as float64, so JSON path filters can compare them.
+func (body *requestBody) jsonPath() (interface{}, error) {
+	body.jsonPathOnce.Do(func() {
+		body.jsonPathErr = json.Unmarshal([]byte(body.raw), &body.jsonPathValue)
+	})
+	return body.jsonPathValue, body.jsonPathErr
+}
+
+// form keeps the pairs parsed before a malformed one.
+func (body *requestBody) form() url.Values {
+	body.formOnce.Do(func() {
+		body.formValues, _ = url.ParseQuery(strings.TrimSpace(body.raw))
+	})
+	return body.formValues
+}
+
+func (body *requestBody) multipart(contentType string) []FileFormData {
+	body.partsOnce.Do(func() {
+		body.partsContentType = contentType
+		body.parts, _ = parseMultipart(contentType, body.raw)
+	})
+	if contentType != body.partsContentType {
+		parts, _ := parseMultipart(contentType, body.raw)
+		return parts
+	}
+	return body.parts
+}
+
+func (body *requestBody) xmlQueryNode() (*xmlquery.Node, error) {
+	body.xmlNodeOnce.Do(func() {
+		body.xmlNode, body.xmlNodeErr = xmlquery.Parse(strings.NewReader(body.raw))
+	})
+	return body.xmlNode, body.xmlNodeErr
+}
+
+func (body *requestBody) xml() (*xmlElement, error) {
+	body.xmlElementOnce.Do(func() {
+		body.xmlElement, body.xmlElementErr = parseXml(body.raw)
+	})
+	return body.xmlElement, body.xmlElementErr
+}
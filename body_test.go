@@ -0,0 +1,197 @@
+package wiregock
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func loadBodyStubs(count int) ([]MockRequest, error) {
+	requests := make([]MockRequest, count)
+	for index := range requests {
+		data := fmt.Sprintf(`{
+			"bodyPatterns": [
+				{"matchesJsonPath": "$.items[%d]"},
+				{"matchesJsonPath": {"expression": "$.items[?(@.id == %d)].name", "equalTo": "item-%d"}},
+				{"matchesJsonSchema": "{\"type\": \"object\", \"required\": [\"items\"]}"},
+				{"equalToJson": "{\"id\": %d}", "ignoreExtraElements": true}
+			]
+		}`, index, index, index, index)
+		if err := json.Unmarshal([]byte(data), &requests[index]); err != nil {
+			return nil, err
+		}
+	}
+	return requests, nil
+}
+
+func loadBody(count int) string {
+	items := make([]string, count)
+	for index := range items {
+		items[index] = fmt.Sprintf(`{"id": %d, "name": "item-%d", "tags": ["a", "b", "c"]}`, index, index)
+	}
+	return fmt.Sprintf(`{"id": %d, "items": [%s]}`, count-1, strings.Join(items, ", "))
+}
+
+func TestRequestBodySharedByStubs(t *testing.T) {
+	requests, err := loadBodyStubs(5)
+	if err != nil {
+		t.Fatalf(`Error parsing stubs: %s`, err)
+	}
+	body := loadBody(5)
+	context := DataContext{Body: func() string { return body }}
+	var parsedBody *requestBody
+	for index, request := range requests {
+		parsedConditions, err := ParseCondition(&request, &context)
+		if err != nil {
+			t.Fatalf(`Error parsing stub %d: %s`, index, err)
+		}
+		res, err := parsedConditions.Condition.Check()
+		if err != nil || res != (index == 4) {
+			t.Fatalf(`Wrong matching of stub %d: got %t. Error: %s`, index, res, err)
+		}
+		if parsedBody == nil {
+			parsedBody = context.body
+		}
+		if context.body != parsedBody {
+			t.Fatalf(`Body parsed again for stub %d`, index)
+		}
+	}
+	if context.body.jsonValue == nil || context.body.jsonPathValue == nil || context.body.xmlNode != nil {
+		t.Fatalf(`Wrong representations of the body are parsed`)
+	}
+}
+
+func TestRequestBodyChanged(t *testing.T) {
+	var request MockRequest
+	if err := json.Unmarshal([]byte(`{"bodyPatterns": [{"equalToJson": "{\"id\": 2}"}]}`), &request); err != nil {
+		t.Fatalf(`Error parsing stub: %s`, err)
+	}
+	body := `{"id": 1}`
+	context := DataContext{Body: func() string { return body }}
+	parsedConditions, err := ParseCondition(&request, &context)
+	if err != nil {
+		t.Fatalf(`Error parsing stub: %s`, err)
+	}
+	for _, valueExpected := range []struct {
+		body     string
+		expected bool
+	}{
+		{`{"id": 1}`, false},
+		{`{"id": 2}`, true},
+		{`{"id": 3}`, false},
+	} {
+		body = valueExpected.body
+		res, err := parsedConditions.Condition.Check()
+		if err != nil || res != valueExpected.expected {
+			t.Fatalf(`Wrong matching of %s: expected %t, got %t. Error: %s`, body, valueExpected.expected, res, err)
+		}
+	}
+	data := []byte(`{"id": 2}`)
+	bytesContext := DataContext{BodyBytes: func() []byte { return data }}
+	parsedConditions, err = ParseCondition(&request, &bytesContext)
+	if err != nil {
+		t.Fatalf(`Error parsing stub: %s`, err)
+	}
+	for _, expected := range []bool{true, false} {
+		if res, err := parsedConditions.Condition.Check(); err != nil || res != expected {
+			t.Fatalf(`Wrong matching of %s: expected %t, got %t. Error: %s`, data, expected, res, err)
+		}
+		data = []byte(`{"id": 1}`)
+	}
+	buffer := []byte(`{"id":1}`)
+	bufferContext := DataContext{BodyBytes: func() []byte { return buffer }}
+	parsedConditions, err = ParseCondition(&request, &bufferContext)
+	if err != nil {
+		t.Fatalf(`Error parsing stub: %s`, err)
+	}
+	for _, expected := range []bool{false, true} {
+		if res, err := parsedConditions.Condition.Check(); err != nil || res != expected {
+			t.Fatalf(`Wrong matching of reused buffer %s: expected %t, got %t. Error: %s`, buffer, expected, res, err)
+		}
+		copy(buffer, `{"id":2}`)
+	}
+}
+
+func TestRequestBodyXml(t *testing.T) {
+	body := newRequestBody(`<order><id>7</id><item>book</item></order>`)
+	xml := `<order><item>book</item><id>7</id></order>`
+	rules := []Rule{
+		EqualToXmlRule{element: mustParseXml(t, xml), EqualToBaseRule: EqualToBaseRule{IgnoreArrayOrder: true}},
+		NotRule{EqualToXmlRule{element: mustParseXml(t, `<order/>`)}},
+	}
+	xPathFilter := XPathFilter{Expression: "//item/text()", Filter: Filter{EqualTo: &[]string{"book"}[0]}}
+	xPathRule, err := XPathXmlFactory{}.generateMatchesXPathRule(&xPathFilter, &XPathFilterProps{})
+	if err != nil {
+		t.Fatalf(`Error creating XPath rule: %s`, err)
+	}
+	rules = append(rules, xPathRule)
+	res, err := checkBody(BlockRule{rulesAnd: rules}, body)
+	if err != nil || !res {
+		t.Fatalf(`XML body doesn't match: %s`, err)
+	}
+	if body.xmlElement == nil || body.xmlNode == nil || body.jsonValue != nil {
+		t.Fatalf(`Wrong representations of the body are parsed`)
+	}
+}
+
+func mustParseXml(t *testing.T, str string) *xmlElement {
+	element, err := parseXml(str)
+	if err != nil {
+		t.Fatalf(`Error parsing %s: %s`, str, err)
+	}
+	return element
+}
+
+func BenchmarkBodyPatterns(b *testing.B) {
+	requests, err := loadBodyStubs(300)
+	if err != nil {
+		b.Fatalf(`Error parsing stubs: %s`, err)
+	}
+	// every iteration is a new request, so the body alternates to be parsed
+	// again
+	bodies := []string{loadBody(300), loadBody(300) + "\n"}
+	b.Run("SharedBody", func(b *testing.B) {
+		body := bodies[0]
+		context := DataContext{Body: func() string { return body }}
+		conditions := parseStubs(b, requests, func(int) *DataContext { return &context })
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			body = bodies[i%2]
+			checkStubs(b, conditions)
+		}
+	})
+	b.Run("BodyPerStub", func(b *testing.B) {
+		body := bodies[0]
+		contexts := make([]DataContext, len(requests))
+		conditions := parseStubs(b, requests, func(index int) *DataContext {
+			contexts[index].Body = func() string { return body }
+			return &contexts[index]
+		})
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			body = bodies[i%2]
+			checkStubs(b, conditions)
+		}
+	})
+}
+
+func parseStubs(b *testing.B, requests []MockRequest, loadContext func(index int) *DataContext) []Condition {
+	conditions := make([]Condition, len(requests))
+	for index := range requests {
+		parsedConditions, err := ParseCondition(&requests[index], loadContext(index))
+		if err != nil {
+			b.Fatalf(`Error parsing stub %d: %s`, index, err)
+		}
+		conditions[index] = parsedConditions.Condition
+	}
+	return conditions
+}
+
+func checkStubs(b *testing.B, conditions []Condition) {
+	for index, condition := range conditions {
+		if _, err := condition.Check(); err != nil {
+			b.Fatalf(`Error checking stub %d: %s`, index, err)
+		}
+	}
+}
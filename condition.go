@@ -1,69 +1,122 @@
 package wiregock
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+var dateTimeLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999", "2006-01-02"}
+
 type Filter struct {
-	Contains            *string       `json:"contains,omitempty" bson:"contains,omitempty"`
-	EqualTo             *string       `json:"equalTo,omitempty" bson:"equalTo,omitempty"`
-	CaseInsensitive     *bool         `json:"caseInsensitive,omitempty" bson:"caseInsensitive,omitempty"`
-	BinaryEqualTo       *string       `json:"binaryEqualTo,omitempty" bson:"binaryEqualTo,omitempty"`
-	DoesNotContain      *string       `json:"doesNotContain,omitempty" bson:"doesNotContain,omitempty"`
-	Matches             *string       `json:"matches,omitempty" bson:"matches,omitempty"`
-	DoesNotMatch        *string       `json:"doesNotMatch,omitempty" bson:"doesNotMatch,omitempty"`
-	Absent              *bool         `json:"absent,omitempty" bson:"absent,omitempty"`
-	And                 []Filter      `json:"and,omitempty" bson:"and,omitempty"`
-	Or                  []Filter      `json:"or,omitempty" bson:"or,omitempty"`
-	Before              *time.Time    `json:"before,omitempty" bson:"before,omitempty"` // "2021-05-01T00:00:00Z"
-	After               *time.Time    `json:"after,omitempty" bson:"after,omitempty"`   // "2021-05-01T00:00:00Z"
-	EqualToDateTime     *time.Time    `json:"equalToDateTime,omitempty" bson:"equalToDateTime,omitempty"`
-	ActualFormat        *string       `json:"actualFormat,omitempty" bson:"actualFormat,omitempty"`
-	EqualToJson         *string       `json:"equalToJson,omitempty" bson:"equalToJson,omitempty"`
-	IgnoreArrayOrder    *bool         `json:"ignoreArrayOrder,omitempty" bson:"ignoreArrayOrder,omitempty"`
-	IgnoreExtraElements *bool         `json:"ignoreExtraElements,omitempty" bson:"ignoreExtraElements,omitempty"`
-	MatchesJsonPath     *XPathFilter  `json:"matchesJsonPath,omitempty" bson:"matchesJsonPath,omitempty"`
-	MatchesJsonSchema   *string       `json:"MatchesJsonSchema,omitempty" bson:"MatchesJsonSchema,omitempty"`
-	EqualToXml          *string       `json:"equalToXml,omitempty" bson:"equalToXml,omitempty"`
-	MatchesXPath        *XPathFilter  `json:"matchesXPath,omitempty" bson:"matchesXPath,omitempty"`
-	Includes            []MultiFilter `json:"includes,omitempty" bson:"includes,omitempty"`
-	HasExactly          []MultiFilter `json:"hasExactly,omitempty" bson:"hasExactly,omitempty"`
+	Contains            *string        `json:"contains,omitempty" bson:"contains,omitempty"`
+	EqualTo             *string        `json:"equalTo,omitempty" bson:"equalTo,omitempty"`
+	CaseInsensitive     *bool          `json:"caseInsensitive,omitempty" bson:"caseInsensitive,omitempty"`
+	BinaryEqualTo       *string        `json:"binaryEqualTo,omitempty" bson:"binaryEqualTo,omitempty"`     // base64
+	StartsWithBytes     *string        `json:"startsWithBytes,omitempty" bson:"startsWithBytes,omitempty"` // base64
+	ContainsBytes       *string        `json:"containsBytes,omitempty" bson:"containsBytes,omitempty"`     // base64
+	Sha256EqualTo       *string        `json:"sha256EqualTo,omitempty" bson:"sha256EqualTo,omitempty"`     // hex
+	DoesNotContain      *string        `json:"doesNotContain,omitempty" bson:"doesNotContain,omitempty"`
+	Matches             *string        `json:"matches,omitempty" bson:"matches,omitempty"`
+	DoesNotMatch        *string        `json:"doesNotMatch,omitempty" bson:"doesNotMatch,omitempty"`
+	Absent              *bool          `json:"absent,omitempty" bson:"absent,omitempty"`
+	And                 []Filter       `json:"and,omitempty" bson:"and,omitempty"`
+	Or                  []Filter       `json:"or,omitempty" bson:"or,omitempty"`
+	Before              *time.Time     `json:"before,omitempty" bson:"before,omitempty"` // "2021-05-01T00:00:00Z"
+	After               *time.Time     `json:"after,omitempty" bson:"after,omitempty"`   // "2021-05-01T00:00:00Z"
+	EqualToDateTime     *time.Time     `json:"equalToDateTime,omitempty" bson:"equalToDateTime,omitempty"`
+	ActualFormat        *string        `json:"actualFormat,omitempty" bson:"actualFormat,omitempty"`
+	ExpectedOffset      *int           `json:"expectedOffset,omitempty" bson:"expectedOffset,omitempty"`
+	ExpectedOffsetUnit  *string        `json:"expectedOffsetUnit,omitempty" bson:"expectedOffsetUnit,omitempty"`
+	TruncateExpected    *string        `json:"truncateExpected,omitempty" bson:"truncateExpected,omitempty"`
+	TruncateActual      *string        `json:"truncateActual,omitempty" bson:"truncateActual,omitempty"`
+	EqualToNumber       *Number        `json:"equalToNumber,omitempty" bson:"equalToNumber,omitempty"`
+	GreaterThan         *Number        `json:"greaterThan,omitempty" bson:"greaterThan,omitempty"`
+	LessThan            *Number        `json:"lessThan,omitempty" bson:"lessThan,omitempty"`
+	Between             *NumberRange   `json:"between,omitempty" bson:"between,omitempty"`
+	Tolerance           *float64       `json:"tolerance,omitempty" bson:"tolerance,omitempty"`
+	EqualToJson         *string        `json:"equalToJson,omitempty" bson:"equalToJson,omitempty"`
+	IgnoreArrayOrder    *bool          `json:"ignoreArrayOrder,omitempty" bson:"ignoreArrayOrder,omitempty"`
+	IgnoreExtraElements *bool          `json:"ignoreExtraElements,omitempty" bson:"ignoreExtraElements,omitempty"`
+	MatchesJsonPath     *XPathFilter   `json:"matchesJsonPath,omitempty" bson:"matchesJsonPath,omitempty"`
+	MatchesJsonSchema   *string        `json:"MatchesJsonSchema,omitempty" bson:"MatchesJsonSchema,omitempty"`
+	SchemaVersion       *string        `json:"schemaVersion,omitempty" bson:"schemaVersion,omitempty"`
+	EqualToXml          *string        `json:"equalToXml,omitempty" bson:"equalToXml,omitempty"`
+	EnablePlaceholders  *bool          `json:"enablePlaceholders,omitempty" bson:"enablePlaceholders,omitempty"`
+	ExemptedComparisons []string       `json:"exemptedComparisons,omitempty" bson:"exemptedComparisons,omitempty"`
+	MatchesXPath        *XPathFilter   `json:"matchesXPath,omitempty" bson:"matchesXPath,omitempty"`
+	Includes            []MultiFilter  `json:"includes,omitempty" bson:"includes,omitempty"`
+	HasExactly          []MultiFilter  `json:"hasExactly,omitempty" bson:"hasExactly,omitempty"`
+	CustomMatcher       *CustomMatcher `json:"customMatcher,omitempty" bson:"customMatcher,omitempty"`
+	MatchesJwt          *JwtFilter     `json:"matchesJwt,omitempty" bson:"matchesJwt,omitempty"`
+	EqualToGraphQL      *string        `json:"equalToGraphQL,omitempty" bson:"equalToGraphQL,omitempty"`
+	MatchesGraphQL      *GraphQLFilter `json:"matchesGraphQL,omitempty" bson:"matchesGraphQL,omitempty"`
+	// expressions like "now +3 days" of the date fields relative to the
+	// current time, by field name
+	dateTimeExpressions map[string]string
+}
+
+type CustomMatcher struct {
+	Name       string                 `json:"name" bson:"name"`
+	Parameters map[string]interface{} `json:"parameters,omitempty" bson:"parameters,omitempty"`
+}
+
+// JwtFilter matches header and payload claims of a JWT by claim name or by
+// JSON path. With a secret or a JWKS file the signature has to be valid too.
+type JwtFilter struct {
+	Header   map[string]Filter `json:"header,omitempty" bson:"header,omitempty"`
+	Payload  map[string]Filter `json:"payload,omitempty" bson:"payload,omitempty"`
+	Secret   *string           `json:"secret,omitempty" bson:"secret,omitempty"`
+	JwksFile *string           `json:"jwksFile,omitempty" bson:"jwksFile,omitempty"`
+}
+
+// GraphQLFilter matches a GraphQL request: the normalized query document,
+// operationName and the variables as JSON.
+type GraphQLFilter struct {
+	Query         *string `json:"query,omitempty" bson:"query,omitempty"`
+	OperationName *Filter `json:"operationName,omitempty" bson:"operationName,omitempty"`
+	Variables     *Filter `json:"variables,omitempty" bson:"variables,omitempty"`
 }
 
 type XPathFilter struct {
-	Expression          string            `json:"-" bson:"-"`
-	EqualTo             *string           `json:"equalTo,omitempty" bson:"equalTo,omitempty"`
-	EqualToJson         *string           `json:"equalToJson,omitempty" bson:"equalToJson,omitempty"`
-	EqualToXml          *string           `json:"equalToXml,omitempty" bson:"equalToXml,omitempty"`
-	Contains            *string           `json:"contains,omitempty" bson:"contains,omitempty"`
-	CaseInsensitive     *bool             `json:"caseInsensitive,omitempty" bson:"caseInsensitive,omitempty"`
-	IgnoreArrayOrder    *bool             `json:"ignoreArrayOrder,omitempty" bson:"ignoreArrayOrder,omitempty"`
-	IgnoreExtraElements *bool             `json:"ignoreExtraElements,omitempty" bson:"ignoreExtraElements,omitempty"`
-	And                 []XPathFilter     `json:"and,omitempty" bson:"and,omitempty"`
-	Before              *time.Time        `json:"before,omitempty" bson:"before,omitempty"` // "2021-05-01T00:00:00Z"
-	After               *time.Time        `json:"after,omitempty" bson:"after,omitempty"`   // "2021-05-01T00:00:00Z"
-	EqualToDateTime     *time.Time        `json:"equalToDateTime,omitempty" bson:"equalToDateTime,omitempty"`
-	ActualFormat        *string           `json:"actualFormat,omitempty" bson:"actualFormat,omitempty"`
-	XPathNamespaces     map[string]string `json:"xPathNamespaces,omitempty" bson:"xPathNamespaces,omitempty"`
+	Expression      string            `json:"expression" bson:"expression"`
+	XPathNamespaces map[string]string `json:"xPathNamespaces,omitempty" bson:"xPathNamespaces,omitempty"`
+	Filter          `bson:",inline"`
 }
 
+type NumberRange struct {
+	Min *Number `json:"min,omitempty" bson:"min,omitempty"`
+	Max *Number `json:"max,omitempty" bson:"max,omitempty"`
+}
+
+// Number is a number as it is written in the stub, so that integers of any
+// size and decimals are compared exactly. It is read from a JSON number or
+// string and from any BSON number.
+type Number string
+
 type MultiFilter struct {
-	EqualTo         *string `json:"equalTo,omitempty" bson:"equalTo,omitempty"`
-	Contains        *string `json:"contains,omitempty" bson:"contains,omitempty"`
-	DoesNotContain  *string `json:"doesNotContain,omitempty" bson:"doesNotContain,omitempty"`
-	CaseInsensitive *bool   `json:"caseInsensitive,omitempty" bson:"caseInsensitive,omitempty"`
+	Filter `bson:",inline"`
 }
 type BasicAuthCredentials struct {
-	Username *string `json:"username,omitempty" bson:"username,omitempty"`
-	Password *string `json:"password,omitempty" bson:"password,omitempty"`
+	Username *Filter `json:"username,omitempty" bson:"username,omitempty"`
+	Password *Filter `json:"password,omitempty" bson:"password,omitempty"`
 }
 
 type MockRequest struct {
+	Url                  *string                 `json:"url,omitempty" bson:"url,omitempty"`
 	UrlPath              *string                 `json:"urlPath,omitempty" bson:"urlPath,omitempty"`
 	UrlPattern           *string                 `json:"urlPattern,omitempty" bson:"urlPattern,omitempty"`
+	UrlPathPattern       *string                 `json:"urlPathPattern,omitempty" bson:"urlPathPattern,omitempty"`
+	UrlPathTemplate      *string                 `json:"urlPathTemplate,omitempty" bson:"urlPathTemplate,omitempty"`
+	PathParameters       map[string]Filter       `json:"pathParameters,omitempty" bson:"pathParameters,omitempty"`
 	Method               *string                 `json:"method,omitempty" bson:"method,omitempty"`
 	Headers              map[string]Filter       `json:"headers,omitempty" bson:"headers,omitempty"`
 	QueryParameters      map[string]Filter       `json:"queryParameters,omitempty" bson:"queryParameters,omitempty"`
@@ -72,6 +125,7 @@ type MockRequest struct {
 	BodyPatterns         []Filter                `json:"bodyPatterns,omitempty" bson:"bodyPatterns,omitempty"`
 	MultipartPatterns    []MultipartPatternsData `json:"multipartPatterns,omitempty" bson:"multipartPatterns,omitempty"`
 	BasicAuthCredentials *BasicAuthCredentials   `json:"basicAuthCredentials,omitempty" bson:"basicAuthCredentials,omitempty"`
+	MatchingType         *string                 `json:"matchingType,omitempty" bson:"matchingType,omitempty"`
 }
 
 type MockResponse struct {
@@ -100,110 +154,322 @@ type Condition interface {
 	Check() (bool, error)
 }
 
+type ExplainableCondition interface {
+	Condition
+	Explain() []MatchResult
+}
+
+type MatchResult struct {
+	Kind     string        `json:"kind"`
+	Name     string        `json:"name,omitempty"`
+	Expected string        `json:"expected"`
+	Actual   string        `json:"actual"`
+	Matched  bool          `json:"matched"`
+	Error    string        `json:"error,omitempty"`
+	AnyOf    []MatchResult `json:"anyOf,omitempty"`
+	literal  string
+}
+
+type conditionInfo struct {
+	kind     string
+	name     string
+	expected string
+	literal  string
+}
+
 type DataCondition struct {
+	conditionInfo
 	loaderMethod func() string
 	blockRule    Rule
 }
 
+type BodyCondition struct {
+	conditionInfo
+	loaderMethod func() *requestBody
+	blockRule    Rule
+}
+
 type MultiDataCondition struct {
+	conditionInfo
 	loaderMethod func() []string
-	rulesAnd     []Rule
-	rulesOr      []Rule
+	includes     []Rule
+	hasExactly   []Rule
 }
 
 type FileDataCondition struct {
-	checkAny      bool
+	conditionInfo
+	matchAll      bool
 	loaderMethod  func() []FileFormData
 	rulesHeader   map[string]Rule
+	absentHeaders map[string]bool
 	rulesFileName Rule
 	rulesBody     Rule
 }
 
+func (info conditionInfo) result(actual string, matched bool, err error) MatchResult {
+	result := MatchResult{
+		Kind:     info.kind,
+		Name:     info.name,
+		Expected: info.expected,
+		Actual:   actual,
+		Matched:  matched && err == nil,
+		literal:  info.literal,
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func (filter *Filter) literal() string {
+	for _, value := range []*string{filter.EqualTo, filter.Contains, filter.BinaryEqualTo, filter.Matches, filter.EqualToJson, filter.EqualToXml} {
+		if value != nil {
+			return *value
+		}
+	}
+	return ""
+}
+
+func describe(value interface{}) string {
+	description, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(description)
+}
+
+func ExplainCondition(condition Condition) []MatchResult {
+	if explainable, ok := condition.(ExplainableCondition); ok {
+		return explainable.Explain()
+	}
+	res, err := condition.Check()
+	return []MatchResult{conditionInfo{kind: "custom"}.result("", res, err)}
+}
+
+func (c DataCondition) load() string {
+	if c.loaderMethod == nil {
+		return ""
+	}
+	return c.loaderMethod()
+}
+
 func (c DataCondition) Check() (bool, error) {
-	data := ""
-	if c.loaderMethod != nil {
-		data = c.loaderMethod()
+	return c.blockRule.Check(c.load())
+}
+
+func (c DataCondition) Explain() []MatchResult {
+	data := c.load()
+	res, err := c.blockRule.Check(data)
+	return []MatchResult{c.result(data, res, err)}
+}
+
+func (c BodyCondition) load() *requestBody {
+	if c.loaderMethod == nil {
+		return newRequestBody("")
 	}
-	return c.blockRule.check(data)
+	return c.loaderMethod()
 }
 
-func (c MultiDataCondition) Check() (bool, error) {
-	var datas []string
+func (c BodyCondition) Check() (bool, error) {
+	return checkBody(c.blockRule, c.load())
+}
+
+func (c BodyCondition) Explain() []MatchResult {
+	body := c.load()
+	res, err := checkBody(c.blockRule, body)
+	return []MatchResult{c.result(body.raw, res, err)}
+}
+
+// load returns no values for a missing parameter, so no matcher of includes
+// or hasExactly can pair with it.
+func (c MultiDataCondition) load() []string {
 	if c.loaderMethod == nil {
-		datas = []string{""}
-	} else {
-		datas = c.loaderMethod()
+		return []string{}
 	}
+	datas := c.loaderMethod()
+	if datas == nil {
+		return []string{}
+	}
+	return datas
+}
 
-	for _, data := range datas {
-		for _, ruleAnd := range c.rulesAnd {
-			val, err := ruleAnd.check(data)
-			if err != nil {
-				return false, err
-			}
-			if !val {
-				return false, nil
-			}
+func (c MultiDataCondition) Check() (bool, error) {
+	return c.check(c.load())
+}
+
+func (c MultiDataCondition) Explain() []MatchResult {
+	datas := c.load()
+	res, err := c.check(datas)
+	return []MatchResult{c.result(describe(datas), res, err)}
+}
+
+// check requires every includes matcher to be satisfied by some value and
+// hasExactly matchers to be paired one-to-one with all the values.
+func (c MultiDataCondition) check(datas []string) (bool, error) {
+	for _, rule := range c.includes {
+		if !slices.ContainsFunc(datas, func(data string) bool { return checkQuietly(rule, data) }) {
+			return false, nil
 		}
 	}
-	resultAnd := (c.rulesAnd == nil) || len(c.rulesAnd) > 0
-	for _, data := range datas {
-		for _, ruleOr := range c.rulesOr {
-			val, err := ruleOr.check(data)
-			if err != nil {
-				return false, err
-			}
-			if val {
-				return true, nil
-			}
+	if len(c.hasExactly) > 0 {
+		if len(c.hasExactly) != len(datas) {
+			return false, nil
 		}
+		return matchAll(len(c.hasExactly), len(datas), func(ruleIndex int, dataIndex int) bool {
+			return checkQuietly(c.hasExactly[ruleIndex], datas[dataIndex])
+		}), nil
+	}
+	return true, nil
+}
+
+// checkQuietly treats a value the rule fails to evaluate as a mismatch, so
+// one malformed value doesn't hide the others.
+func checkQuietly(rule Rule, data string) bool {
+	res, err := rule.Check(data)
+	return err == nil && res
+}
+
+type BasicAuthCondition struct {
+	conditionInfo
+	loaderMethod func() string
+	usernameRule Rule
+	passwordRule Rule
+}
+
+func parseBasicAuth(header string) (string, string, bool) {
+	scheme, credentials, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return "", "", false
+	}
+	credentials = strings.TrimRight(strings.TrimSpace(credentials), "=")
+	decoded, err := base64.RawStdEncoding.DecodeString(credentials)
+	if err != nil {
+		decoded, err = base64.RawURLEncoding.DecodeString(credentials)
+		if err != nil {
+			return "", "", false
+		}
+	}
+	return strings.Cut(string(decoded), ":")
+}
+
+func (c BasicAuthCondition) Check() (bool, error) {
+	header := ""
+	if c.loaderMethod != nil {
+		header = c.loaderMethod()
 	}
-	resultOr := (c.rulesOr == nil) || len(c.rulesOr) == 0
-	return resultAnd && resultOr, nil
+	_, res, err := c.check(header)
+	return res, err
 }
 
+func (c BasicAuthCondition) Explain() []MatchResult {
+	header := ""
+	if c.loaderMethod != nil {
+		header = c.loaderMethod()
+	}
+	username, res, err := c.check(header)
+	return []MatchResult{c.result(username, res, err)}
+}
+
+func (c BasicAuthCondition) check(header string) (string, bool, error) {
+	username, password, ok := parseBasicAuth(header)
+	if !ok {
+		return "", false, nil
+	}
+	if c.usernameRule != nil {
+		res, err := c.usernameRule.Check(username)
+		if err != nil || !res {
+			return username, false, err
+		}
+	}
+	if c.passwordRule != nil {
+		res, err := c.passwordRule.Check(password)
+		if err != nil || !res {
+			return username, false, err
+		}
+	}
+	return username, true, nil
+}
+
+func (c FileDataCondition) Explain() []MatchResult {
+	fileNames := []string{}
+	if c.loaderMethod != nil {
+		for _, formData := range c.loaderMethod() {
+			fileNames = append(fileNames, formData.FileName)
+		}
+	}
+	res, err := c.Check()
+	return []MatchResult{c.result(describe(fileNames), res, err)}
+}
+
+// Check is true if any part, or every part with matchingType ALL, matches
+// all the header, file name and body matchers. A body without parts never
+// matches, and a part the matchers fail to evaluate doesn't match.
 func (c FileDataCondition) Check() (bool, error) {
-	hasRules := c.rulesBody != nil || len(c.rulesHeader) > 0
 	if c.loaderMethod == nil {
-		return hasRules, nil
+		return false, nil
+	}
+	parts := c.loaderMethod()
+	if len(parts) == 0 {
+		return false, nil
 	}
-	for _, formData := range c.loaderMethod() {
-		for ruleKey, rule := range c.rulesHeader {
-			headers, ok := formData.Headers[ruleKey]
-			if !ok && c.checkAny {
+	for _, formData := range parts {
+		res, err := c.checkPart(formData)
+		res = err == nil && res
+		if res != c.matchAll {
+			return res, nil
+		}
+	}
+	return c.matchAll, nil
+}
+
+// checkPart accepts a missing header only if it is expected to be absent. A
+// header with several values matches if any of them does.
+func (c FileDataCondition) checkPart(formData FileFormData) (bool, error) {
+	for key, rule := range c.rulesHeader {
+		headers := partHeader(formData.Headers, key)
+		if len(headers) == 0 {
+			if c.absentHeaders[key] {
 				continue
 			}
-			for _, header := range headers {
-				val, err := rule.check(header)
-				if err != nil {
-					return false, err
-				}
-				if val == c.checkAny {
-					return val, nil
-				}
-			}
+			return false, nil
 		}
-		if c.rulesBody != nil {
-			val, err := c.rulesBody.check(formData.Data)
+		matched := false
+		for _, header := range headers {
+			res, err := rule.Check(header)
 			if err != nil {
 				return false, err
 			}
-			if val == c.checkAny {
-				return val, nil
+			if res {
+				matched = true
+				break
 			}
 		}
+		if !matched {
+			return false, nil
+		}
+	}
+	if c.rulesFileName != nil {
+		res, err := c.rulesFileName.Check(formData.FileName)
+		if err != nil || !res {
+			return false, err
+		}
+	}
+	if c.rulesBody != nil {
+		return checkBody(c.rulesBody, newRequestBody(formData.Data))
+	}
+	return true, nil
+}
 
-		if c.rulesFileName != nil {
-			val, err := c.rulesFileName.check(formData.FileName)
-			if err != nil {
-				return false, err
-			}
-			if val == c.checkAny {
-				return val, nil
-			}
+func partHeader(headers map[string][]string, key string) []string {
+	if values, ok := headers[key]; ok {
+		return values
+	}
+	for name, values := range headers {
+		if strings.EqualFold(name, key) {
+			return values
 		}
 	}
-	return hasRules, nil
+	return nil
 }
 
 type AndCondition struct {
@@ -223,117 +489,349 @@ func (c AndCondition) Check() (bool, error) {
 	return true, nil
 }
 
+func (c AndCondition) Explain() []MatchResult {
+	return explainConditions(c.conditions)
+}
+
 type OrCondition struct {
 	conditions []Condition
 }
 
+// Check is true if any of the conditions is. A condition that fails to
+// evaluate doesn't match, so it can't hide the ones after it.
 func (c OrCondition) Check() (bool, error) {
 	for _, cond := range c.conditions {
 		res, err := cond.Check()
-		if err != nil {
-			return false, err
-		}
-		if res {
+		if err == nil && res {
 			return true, nil
 		}
 	}
 	return false, nil
 }
 
-func (xPathFilter *XPathFilter) UnmarshalJSON(data []byte) error {
-	switch data[0] {
-	case '"':
-		var expression string
-		if err := json.Unmarshal(data, &expression); err != nil {
-			return err
-		}
-		xPathFilter.Expression = expression
-	case '{':
-		var fieldsData interface{}
-		if err := json.Unmarshal(data, &fieldsData); err != nil {
+// Explain keeps the results of the conditions under a single "anyOf" result,
+// which matches if any of the conditions does.
+func (c OrCondition) Explain() []MatchResult {
+	res, err := c.Check()
+	result := conditionInfo{kind: "anyOf"}.result("", res, err)
+	result.AnyOf = explainConditions(c.conditions)
+	return []MatchResult{result}
+}
+
+// NewDataCondition checks the value returned by loaderMethod with the rule.
+// kind and name only label the MatchResult of Explain.
+func NewDataCondition(kind string, name string, loaderMethod func() string, rule Rule) DataCondition {
+	return DataCondition{conditionInfo{kind: kind, name: name}, loaderMethod, rule}
+}
+
+func NewAndCondition(conditions ...Condition) AndCondition {
+	return AndCondition{conditions}
+}
+
+func NewOrCondition(conditions ...Condition) OrCondition {
+	return OrCondition{conditions}
+}
+
+func explainConditions(conditions []Condition) []MatchResult {
+	results := []MatchResult{}
+	for _, cond := range conditions {
+		results = append(results, ExplainCondition(cond)...)
+	}
+	return results
+}
+
+func (filter *Filter) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var equalTo string
+		if err := json.Unmarshal(data, &equalTo); err != nil {
 			return err
 		}
+		*filter = Filter{EqualTo: &equalTo}
+		return nil
+	}
+	type filterFields Filter
+	fields := struct {
+		*filterFields
+		Before          *string `json:"before,omitempty"`
+		After           *string `json:"after,omitempty"`
+		EqualToDateTime *string `json:"equalToDateTime,omitempty"`
+	}{filterFields: (*filterFields)(filter)}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	return filter.loadDateTimes(fields.Before, fields.After, fields.EqualToDateTime)
+}
 
-		var objmap map[string]json.RawMessage
-		err := json.Unmarshal(data, &objmap)
+// loadDateTimes sets the date fields given as strings and keeps the others,
+// which BSON may hold as dates.
+func (filter *Filter) loadDateTimes(before *string, after *string, equalToDateTime *string) error {
+	filter.dateTimeExpressions = nil
+	for _, dateTime := range []struct {
+		name  string
+		str   *string
+		value **time.Time
+	}{
+		{"before", before, &filter.Before},
+		{"after", after, &filter.After},
+		{"equalToDateTime", equalToDateTime, &filter.EqualToDateTime},
+	} {
+		if dateTime.str == nil {
+			continue
+		}
+		value, err := filter.parseDateTime(dateTime.name, dateTime.str)
 		if err != nil {
 			return err
 		}
+		*dateTime.value = value
+	}
+	return nil
+}
 
-		fields := fieldsData.(map[string]interface{})
-		expression, ok := fields["expression"].(string)
-		if ok {
-			xPathFilter.Expression = expression
-		}
-		equalToJson, ok := fields["equalToJson"].(string)
-		if ok {
-			xPathFilter.EqualToJson = &equalToJson
-		}
-		equalToXml, ok := fields["equalToXml"].(string)
-		if ok {
-			xPathFilter.EqualToXml = &equalToXml
+// parseDateTime accepts RFC 3339 values as well as local date-times and
+// dates, which are taken as UTC. An expression like "now +3 days" is kept
+// as it is and resolved on every check; the field only holds a zero time.
+func (filter *Filter) parseDateTime(name string, str *string) (*time.Time, error) {
+	if str == nil {
+		return nil, nil
+	}
+	if _, ok, err := parseNowExpression(*str); ok {
+		if err != nil {
+			return nil, err
 		}
-		contains, ok := fields["contains"].(string)
-		if ok {
-			xPathFilter.Contains = &contains
+		if filter.dateTimeExpressions == nil {
+			filter.dateTimeExpressions = map[string]string{}
 		}
-		xPathNamespacesBytes, ok := objmap["xPathNamespaces"]
-		if ok {
-			xPathNamespaces := make(map[string]string)
-			if err := json.Unmarshal(xPathNamespacesBytes, &xPathNamespaces); err != nil {
-				return err
-			}
-			xPathFilter.XPathNamespaces = xPathNamespaces
+		filter.dateTimeExpressions[name] = *str
+		return &time.Time{}, nil
+	}
+	var err error
+	for _, layout := range dateTimeLayouts {
+		var dateTime time.Time
+		dateTime, err = time.Parse(layout, *str)
+		if err == nil {
+			return &dateTime, nil
 		}
 	}
-	return nil
+	return nil, err
 }
 
-func (xPathFilter *XPathFilter) UnmarshalBSON(data []byte) error {
-	switch data[0] {
-	case '"':
-		var expression string
-		if err := bson.Unmarshal(data, &expression); err != nil {
-			return err
+// parseNowExpression reads the offset of "now" or "now <amount> <unit>". It
+// isn't ok if the expression doesn't start with "now".
+func parseNowExpression(str string) (dateTimeOffset, bool, error) {
+	fields := strings.Fields(str)
+	if len(fields) == 0 || !strings.EqualFold(fields[0], "now") {
+		return dateTimeOffset{}, false, nil
+	}
+	if len(fields) == 1 {
+		return dateTimeOffset{}, true, nil
+	}
+	if len(fields) != 3 {
+		return dateTimeOffset{}, true, fmt.Errorf("invalid date-time expression: %s", str)
+	}
+	amount, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return dateTimeOffset{}, true, fmt.Errorf("invalid date-time expression: %s", str)
+	}
+	offset, err := loadDateTimeOffset(&amount, &fields[2])
+	if err != nil {
+		return dateTimeOffset{}, true, fmt.Errorf("invalid date-time expression %s: %w", str, err)
+	}
+	return offset, true, nil
+}
+
+// dateTimeStrings formats the date fields the way UnmarshalJSON reads them,
+// keeping the expressions relative to the current time.
+func (filter Filter) dateTimeStrings() (before *string, after *string, equalToDateTime *string) {
+	format := func(name string, dateTime *time.Time) *string {
+		if expression, ok := filter.dateTimeExpressions[name]; ok {
+			return &expression
 		}
-		xPathFilter.Expression = expression
-	case '{':
-		var fieldsData interface{}
-		if err := bson.Unmarshal(data, &fieldsData); err != nil {
-			return err
+		if dateTime == nil {
+			return nil
 		}
+		str := dateTime.Format(time.RFC3339Nano)
+		return &str
+	}
+	return format("before", filter.Before), format("after", filter.After), format("equalToDateTime", filter.EqualToDateTime)
+}
 
-		var objmap map[string]bson.Raw
-		err := json.Unmarshal(data, &objmap)
-		if err != nil {
-			return err
-		}
+func (filter Filter) MarshalJSON() ([]byte, error) {
+	type filterFields Filter
+	fields := struct {
+		filterFields
+		Before          *string `json:"before,omitempty"`
+		After           *string `json:"after,omitempty"`
+		EqualToDateTime *string `json:"equalToDateTime,omitempty"`
+	}{filterFields: filterFields(filter)}
+	fields.Before, fields.After, fields.EqualToDateTime = filter.dateTimeStrings()
+	return json.Marshal(fields)
+}
 
-		fields := fieldsData.(map[string]interface{})
-		expression, ok := fields["expression"].(string)
-		if ok {
-			xPathFilter.Expression = expression
-		}
-		equalToJson, ok := fields["equalToJson"].(string)
-		if ok {
-			xPathFilter.EqualToJson = &equalToJson
-		}
-		equalToXml, ok := fields["equalToXml"].(string)
-		if ok {
-			xPathFilter.EqualToXml = &equalToXml
+func (filter Filter) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	type filterFields Filter
+	data, err := bson.Marshal(filterFields(filter))
+	if err != nil || len(filter.dateTimeExpressions) == 0 {
+		return bson.TypeEmbeddedDocument, data, err
+	}
+	var document bson.D
+	if err := bson.Unmarshal(data, &document); err != nil {
+		return 0, nil, err
+	}
+	// the zero times of the expressions are left out as empty
+	document = slices.DeleteFunc(document, func(element bson.E) bool {
+		_, ok := filter.dateTimeExpressions[element.Key]
+		return ok
+	})
+	for _, name := range []string{"before", "after", "equalToDateTime"} {
+		if expression, ok := filter.dateTimeExpressions[name]; ok {
+			document = append(document, bson.E{Key: name, Value: expression})
 		}
-		contains, ok := fields["contains"].(string)
-		if ok {
-			xPathFilter.Contains = &contains
+	}
+	return bson.MarshalValue(document)
+}
+
+func (filter *Filter) UnmarshalBSONValue(bsonType bsontype.Type, data []byte) error {
+	rawValue := bson.RawValue{Type: bsonType, Value: data}
+	if bsonType == bson.TypeString {
+		equalTo := rawValue.StringValue()
+		*filter = Filter{EqualTo: &equalTo}
+		return nil
+	}
+	var document bson.D
+	if err := rawValue.Unmarshal(&document); err != nil {
+		return err
+	}
+	dateTimes := make(map[string]*string)
+	fields := bson.D{}
+	for _, element := range document {
+		if str, ok := element.Value.(string); ok && slices.Contains([]string{"before", "after", "equalToDateTime"}, element.Key) {
+			dateTimes[element.Key] = &str
+			continue
 		}
-		xPathNamespacesBytes, ok := objmap["xPathNamespaces"]
-		if ok {
-			xPathNamespaces := make(map[string]string)
-			if err := bson.Unmarshal(xPathNamespacesBytes, &xPathNamespaces); err != nil {
-				return err
-			}
-			xPathFilter.XPathNamespaces = xPathNamespaces
+		fields = append(fields, element)
+	}
+	fieldsData, err := bson.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	type filterFields Filter
+	if err := bson.Unmarshal(fieldsData, (*filterFields)(filter)); err != nil {
+		return err
+	}
+	return filter.loadDateTimes(dateTimes["before"], dateTimes["after"], dateTimes["equalToDateTime"])
+}
+
+func (xPathFilter *XPathFilter) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &xPathFilter.Expression)
+	}
+	var fields struct {
+		Expression      string            `json:"expression"`
+		XPathNamespaces map[string]string `json:"xPathNamespaces"`
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	xPathFilter.Expression = fields.Expression
+	xPathFilter.XPathNamespaces = fields.XPathNamespaces
+	return json.Unmarshal(data, &xPathFilter.Filter)
+}
+
+func (xPathFilter XPathFilter) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(xPathFilter.Filter)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	if fields["expression"], err = json.Marshal(xPathFilter.Expression); err != nil {
+		return nil, err
+	}
+	if xPathFilter.XPathNamespaces != nil {
+		if fields["xPathNamespaces"], err = json.Marshal(xPathFilter.XPathNamespaces); err != nil {
+			return nil, err
 		}
 	}
+	return json.Marshal(fields)
+}
+
+func (xPathFilter XPathFilter) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	_, data, err := xPathFilter.Filter.MarshalBSONValue()
+	if err != nil {
+		return 0, nil, err
+	}
+	var fields bson.D
+	if err := bson.Unmarshal(data, &fields); err != nil {
+		return 0, nil, err
+	}
+	document := bson.D{{Key: "expression", Value: xPathFilter.Expression}}
+	if xPathFilter.XPathNamespaces != nil {
+		document = append(document, bson.E{Key: "xPathNamespaces", Value: xPathFilter.XPathNamespaces})
+	}
+	return bson.MarshalValue(append(document, fields...))
+}
+
+func (xPathFilter *XPathFilter) UnmarshalBSONValue(bsonType bsontype.Type, data []byte) error {
+	rawValue := bson.RawValue{Type: bsonType, Value: data}
+	if bsonType == bson.TypeString {
+		xPathFilter.Expression = rawValue.StringValue()
+		return nil
+	}
+	var fields struct {
+		Expression      string            `bson:"expression"`
+		XPathNamespaces map[string]string `bson:"xPathNamespaces"`
+	}
+	if err := rawValue.Unmarshal(&fields); err != nil {
+		return err
+	}
+	xPathFilter.Expression = fields.Expression
+	xPathFilter.XPathNamespaces = fields.XPathNamespaces
+	return xPathFilter.Filter.UnmarshalBSONValue(bsonType, data)
+}
+
+func (number *Number) UnmarshalJSON(data []byte) error {
+	var value json.Number
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	*number = Number(value)
 	return nil
 }
+
+func (number Number) MarshalJSON() ([]byte, error) {
+	return json.Marshal(json.Number(number))
+}
+
+func (number *Number) UnmarshalBSONValue(bsonType bsontype.Type, data []byte) error {
+	rawValue := bson.RawValue{Type: bsonType, Value: data}
+	switch bsonType {
+	case bson.TypeInt32:
+		*number = Number(strconv.FormatInt(int64(rawValue.Int32()), 10))
+	case bson.TypeInt64:
+		*number = Number(strconv.FormatInt(rawValue.Int64(), 10))
+	case bson.TypeDouble:
+		*number = Number(strconv.FormatFloat(rawValue.Double(), 'g', -1, 64))
+	case bson.TypeDecimal128:
+		*number = Number(rawValue.Decimal128().String())
+	case bson.TypeString:
+		*number = Number(rawValue.StringValue())
+	default:
+		return fmt.Errorf("invalid number of BSON type %s", bsonType)
+	}
+	return nil
+}
+
+// MarshalBSONValue keeps integers as int64 if they fit, and the other
+// numbers as decimal128, which keeps them exact.
+func (number Number) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	if value, err := strconv.ParseInt(string(number), 10, 64); err == nil {
+		return bson.MarshalValue(value)
+	}
+	value, err := primitive.ParseDecimal128(string(number))
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid number: %s", number)
+	}
+	return bson.MarshalValue(value)
+}
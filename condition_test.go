@@ -3,6 +3,7 @@ package wiregock
 import (
 	"encoding/json"
 	"testing"
+	"time"
     "reflect"
     "go.mongodb.org/mongo-driver/bson"
 )
@@ -90,10 +91,10 @@ func TestUnmarshaling(t *testing.T) {
     if mockData.Request.BodyPatterns[1].MatchesXPath.Expression != "//search-results" {
         t.Fatalf(`Unable to load from parsed JSON: %s`, "mockData.Request.BodyPatterns[1].MatchesXPath.Expression")
     }
-    if *mockData.Request.BasicAuthCredentials.Username != "jeff@example.com" {
+    if *mockData.Request.BasicAuthCredentials.Username.EqualTo != "jeff@example.com" {
         t.Fatalf(`Unable to load from parsed JSON: %s`, "mockData.Request.BasicAuthCredentials.Username")
     }
-    if *mockData.Request.BasicAuthCredentials.Password != "jeffteenjefftyjeff" {
+    if *mockData.Request.BasicAuthCredentials.Password.EqualTo != "jeffteenjefftyjeff" {
         t.Fatalf(`Unable to load from parsed JSON: %s`, "mockData.Request.BasicAuthCredentials.Password")
     }
 }
@@ -229,4 +230,231 @@ func TestUnmarshalingBsonXPathFilter(t *testing.T) {
     if !reflect.DeepEqual(source, sourceRestored) {
         t.Fatalf(`Wrong unmarshaling`)
     }
-}
\ No newline at end of file
+}
+func TestExplainCondition(t *testing.T) {
+	accept, search := "xml", "WireMock"
+	request := MockRequest{
+		Headers:         map[string]Filter{"Accept": {Contains: &accept}},
+		QueryParameters: map[string]Filter{"search_term": {EqualTo: &search}},
+	}
+	context := DataContext{
+		Get:    func(key string) string { return "application/json" },
+		Params: func(key string) string { return "WireMock" },
+	}
+	parsedConditions, err := ParseCondition(&request, &context)
+	if err != nil {
+		t.Fatalf(`Error parsing conditions: %s`, err)
+	}
+	results := parsedConditions.Explain()
+	if len(results) != 2 {
+		t.Fatalf(`Wrong number of explained conditions: %d`, len(results))
+	}
+	for _, result := range results {
+		switch result.Kind {
+		case "header":
+			if result.Matched || result.Name != "Accept" || result.Actual != "application/json" || result.Expected != `{"contains":"xml"}` {
+				t.Fatalf(`Wrong explanation of header condition: %+v`, result)
+			}
+		case "query":
+			if !result.Matched || result.Name != "search_term" || result.Actual != "WireMock" {
+				t.Fatalf(`Wrong explanation of query condition: %+v`, result)
+			}
+		default:
+			t.Fatalf(`Unexpected explanation: %+v`, result)
+		}
+	}
+}
+
+func TestUnmarshalingFilterShorthand(t *testing.T) {
+	var credentials BasicAuthCredentials
+	err := json.Unmarshal([]byte(`{"username": "jeff", "password": {"matches": "j.*"}}`), &credentials)
+	if err != nil {
+		t.Fatalf(`Error parsing JSON format: %s`, err)
+	}
+	if *credentials.Username.EqualTo != "jeff" || *credentials.Password.Matches != "j.*" {
+		t.Fatalf(`Unable to load credentials from parsed JSON: %+v`, credentials)
+	}
+	bin, err := bson.Marshal(bson.M{"username": "jeff", "password": bson.M{"matches": "j.*"}})
+	if err != nil {
+		t.Fatalf(`Binary marshaling error: %s`, err)
+	}
+	credentials = BasicAuthCredentials{}
+	if err := bson.Unmarshal(bin, &credentials); err != nil {
+		t.Fatalf(`Error parsing BSON format: %s`, err)
+	}
+	if *credentials.Username.EqualTo != "jeff" || *credentials.Password.Matches != "j.*" {
+		t.Fatalf(`Unable to load credentials from parsed BSON: %+v`, credentials)
+	}
+}
+
+func TestBasicAuthCondition(t *testing.T) {
+	username, password := "jeff@example.com", "jeff.*"
+	request := MockRequest{BasicAuthCredentials: &BasicAuthCredentials{
+		Username: &Filter{EqualTo: &username},
+		Password: &Filter{Matches: &password},
+	}}
+	headersExpected := map[string]bool{
+		"Basic amVmZkBleGFtcGxlLmNvbTpqZWZmdGVlbmplZmZ0eWplZmY=":  true,
+		"basic   amVmZkBleGFtcGxlLmNvbTpqZWZmdGVlbmplZmZ0eWplZmY": true,
+		"Basic amVmZkBleGFtcGxlLmNvbTpvdGhlcg==":                  false,
+		"Basic not base64!":                                       false,
+		"Basic amVmZkBleGFtcGxlLmNvbQ==":                          false,
+		"Bearer amVmZkBleGFtcGxlLmNvbTpqZWZmdGVlbmplZmZ0eWplZmY=": false,
+		"": false,
+	}
+	for header, expected := range headersExpected {
+		context := DataContext{Get: func(key string) string {
+			if key == "Authorization" {
+				return header
+			}
+			return ""
+		}}
+		parsedConditions, err := ParseCondition(&request, &context)
+		if err != nil {
+			t.Fatalf(`Error parsing basic auth condition: %s`, err)
+		}
+		res, err := parsedConditions.Condition.Check()
+		if err != nil || res != expected {
+			t.Fatalf(`Wrong matching of %q: expected %t, got %t. Error: %s`, header, expected, res, err)
+		}
+	}
+}
+
+func TestUnmarshalingFilterRelativeDateTime(t *testing.T) {
+	var filter Filter
+	err := json.Unmarshal([]byte(`{"before": "now +3 days", "truncateExpected": "first day of month"}`), &filter)
+	if err != nil {
+		t.Fatalf(`Error parsing relative date-time: %s`, err)
+	}
+	if filter.Before == nil || !filter.Before.IsZero() || filter.dateTimeExpressions["before"] != "now +3 days" || filter.ExpectedOffset != nil {
+		t.Fatalf(`Wrong relative date-time: %v %v`, filter.Before, filter.dateTimeExpressions)
+	}
+	err = json.Unmarshal([]byte(`{"before": "now +3 fortnights"}`), &Filter{})
+	if err == nil {
+		t.Fatalf(`Unknown offset unit accepted`)
+	}
+	bin, err := bson.Marshal(bson.M{"filter": bson.M{"after": "now -1 hours", "equalToDateTime": "2021-05-01", "actualFormat": "epoch"}})
+	if err != nil {
+		t.Fatalf(`bson.Raw marshaling error: %s`, err)
+	}
+	var restored struct {
+		Filter Filter `bson:"filter"`
+	}
+	if err = bson.Unmarshal(bin, &restored); err != nil {
+		t.Fatalf(`bson.Raw unmarshaling error: %s`, err)
+	}
+	bsonFilter := restored.Filter
+	if bsonFilter.After == nil || !bsonFilter.After.IsZero() || bsonFilter.dateTimeExpressions["after"] != "now -1 hours" || *bsonFilter.ActualFormat != "epoch" {
+		t.Fatalf(`Wrong relative date-time from bson: %v %v`, bsonFilter.After, bsonFilter.dateTimeExpressions)
+	}
+	if !bsonFilter.EqualToDateTime.Equal(time.Date(2021, time.May, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf(`Wrong date-time from bson: %v`, bsonFilter.EqualToDateTime)
+	}
+}
+
+func TestMarshalingFilterRelativeDateTime(t *testing.T) {
+	var filter XPathFilter
+	err := json.Unmarshal([]byte(`{"expression": "$.date", "before": "now +3 days", "after": "2021-05-01T00:00:00Z"}`), &filter)
+	if err != nil {
+		t.Fatalf(`Error parsing relative date-time: %s`, err)
+	}
+	var jsonFilter, bsonFilter XPathFilter
+	data, err := json.Marshal(filter)
+	if err != nil {
+		t.Fatalf(`Error marshaling to JSON: %s`, err)
+	}
+	if err := json.Unmarshal(data, &jsonFilter); err != nil {
+		t.Fatalf(`Error parsing %s: %s`, data, err)
+	}
+	bin, err := bson.Marshal(bson.M{"filter": filter})
+	if err != nil {
+		t.Fatalf(`bson.Raw marshaling error: %s`, err)
+	}
+	var restored struct {
+		Filter XPathFilter `bson:"filter"`
+	}
+	if err := bson.Unmarshal(bin, &restored); err != nil {
+		t.Fatalf(`bson.Raw unmarshaling error: %s`, err)
+	}
+	bsonFilter = restored.Filter
+	value := time.Now().UTC().AddDate(0, 0, 2).Format(time.RFC3339)
+	for title, restoredFilter := range map[string]XPathFilter{"JSON": jsonFilter, "BSON": bsonFilter} {
+		if restoredFilter.Expression != "$.date" {
+			t.Fatalf(`Expression lost in %s: %+v`, title, restoredFilter)
+		}
+		rule, err := NewRule(&restoredFilter.Filter)
+		if err != nil {
+			t.Fatalf(`Error creating rule from %s: %s`, title, err)
+		}
+		if res, err := rule.Check(value); err != nil || !res {
+			t.Fatalf(`Relative date-time lost in %s: %+v. Error: %s`, title, restoredFilter.Filter, err)
+		}
+	}
+}
+
+func TestMarshalingNumber(t *testing.T) {
+	var filter Filter
+	if err := json.Unmarshal([]byte(`{"equalToNumber": 9007199254740993, "between": {"min": 0.1, "max": 1e40}}`), &filter); err != nil {
+		t.Fatalf(`Error parsing numbers: %s`, err)
+	}
+	data, err := json.Marshal(filter)
+	if err != nil || string(data) != `{"equalToNumber":9007199254740993,"between":{"min":0.1,"max":1e40}}` {
+		t.Fatalf(`Wrong numbers in JSON %s. Error: %s`, data, err)
+	}
+	bin, err := bson.Marshal(bson.M{"filter": filter})
+	if err != nil {
+		t.Fatalf(`bson.Raw marshaling error: %s`, err)
+	}
+	var restored struct {
+		Filter Filter `bson:"filter"`
+	}
+	if err := bson.Unmarshal(bin, &restored); err != nil {
+		t.Fatalf(`bson.Raw unmarshaling error: %s`, err)
+	}
+	rule, err := NewRule(&restored.Filter)
+	if err != nil {
+		t.Fatalf(`Error creating rule: %s`, err)
+	}
+	if res, err := rule.Check("9007199254740993"); err != nil || !res {
+		t.Fatalf(`Wrong numbers from BSON: %s %+v`, *restored.Filter.EqualToNumber, *restored.Filter.Between)
+	}
+	if res, err := rule.Check("9007199254740992"); err != nil || res {
+		t.Fatalf(`Wrong numbers from BSON: %s %+v`, *restored.Filter.EqualToNumber, *restored.Filter.Between)
+	}
+	bin, err = bson.Marshal(bson.M{"filter": bson.M{"greaterThan": int32(10), "lessThan": 12.5}})
+	if err != nil {
+		t.Fatalf(`bson.Raw marshaling error: %s`, err)
+	}
+	if err := bson.Unmarshal(bin, &restored); err != nil {
+		t.Fatalf(`bson.Raw unmarshaling error: %s`, err)
+	}
+	if *restored.Filter.GreaterThan != "10" || *restored.Filter.LessThan != "12.5" {
+		t.Fatalf(`Wrong numbers from BSON: %s %s`, *restored.Filter.GreaterThan, *restored.Filter.LessThan)
+	}
+}
+
+func TestNewConditions(t *testing.T) {
+	header := "application/json"
+	contentType := NewDataCondition("header", "Content-Type", func() string { return header }, NewContainsRule("json", false))
+	method := NewDataCondition("method", "", func() string { return "GET" }, NewEqualToRule("POST", false))
+	conditionsExpected := []struct {
+		condition Condition
+		expected  bool
+	}{
+		{contentType, true},
+		{NewAndCondition(contentType, method), false},
+		{NewOrCondition(contentType, method), true},
+		{NewOrCondition(), false},
+		{NewAndCondition(), true},
+	}
+	for index, conditionExpected := range conditionsExpected {
+		res, err := conditionExpected.condition.Check()
+		if err != nil || res != conditionExpected.expected {
+			t.Fatalf(`Wrong condition #%d: expected %t, got %t. Error: %s`, index, conditionExpected.expected, res, err)
+		}
+	}
+	results := ExplainCondition(NewAndCondition(contentType, method))
+	if len(results) != 2 || results[0].Kind != "header" || results[0].Name != "Content-Type" || results[0].Actual != header || results[1].Matched {
+		t.Fatalf(`Wrong explanation: %+v`, results)
+	}
+}
@@ -0,0 +1,135 @@
+package wiregock
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	dateTimeFormatUnix  = "unix"
+	dateTimeFormatEpoch = "epoch"
+)
+
+const (
+	truncateFirstMinuteOfHour   = "first minute of hour"
+	truncateFirstHourOfDay      = "first hour of day"
+	truncateFirstDayOfMonth     = "first day of month"
+	truncateFirstDayOfNextMonth = "first day of next month"
+	truncateLastDayOfMonth      = "last day of month"
+	truncateFirstDayOfYear      = "first day of year"
+	truncateFirstDayOfNextYear  = "first day of next year"
+	truncateLastDayOfYear       = "last day of year"
+)
+
+var dateTimeTruncations = []string{
+	truncateFirstMinuteOfHour,
+	truncateFirstHourOfDay,
+	truncateFirstDayOfMonth,
+	truncateFirstDayOfNextMonth,
+	truncateLastDayOfMonth,
+	truncateFirstDayOfYear,
+	truncateFirstDayOfNextYear,
+	truncateLastDayOfYear,
+}
+
+type dateTimeOffset struct {
+	amount int
+	unit   string
+}
+
+func loadDateTimeOffset(amount *int, unit *string) (dateTimeOffset, error) {
+	if amount == nil {
+		if unit != nil {
+			return dateTimeOffset{}, fmt.Errorf("expectedOffsetUnit %s requires expectedOffset", *unit)
+		}
+		return dateTimeOffset{}, nil
+	}
+	offset := dateTimeOffset{amount: *amount, unit: "days"}
+	if unit != nil {
+		offset.unit = strings.TrimSuffix(strings.ToLower(*unit), "s") + "s"
+	}
+	switch offset.unit {
+	case "seconds", "minutes", "hours", "days", "weeks", "months", "years":
+		return offset, nil
+	}
+	return dateTimeOffset{}, fmt.Errorf("unknown expectedOffsetUnit: %s", offset.unit)
+}
+
+func (offset dateTimeOffset) apply(dateTime time.Time) time.Time {
+	switch offset.unit {
+	case "seconds":
+		return dateTime.Add(time.Duration(offset.amount) * time.Second)
+	case "minutes":
+		return dateTime.Add(time.Duration(offset.amount) * time.Minute)
+	case "hours":
+		return dateTime.Add(time.Duration(offset.amount) * time.Hour)
+	case "days":
+		return dateTime.AddDate(0, 0, offset.amount)
+	case "weeks":
+		return dateTime.AddDate(0, 0, 7*offset.amount)
+	case "months":
+		return dateTime.AddDate(0, offset.amount, 0)
+	case "years":
+		return dateTime.AddDate(offset.amount, 0, 0)
+	}
+	return dateTime
+}
+
+func loadDateTimeTruncation(truncation *string) (string, error) {
+	if truncation == nil {
+		return "", nil
+	}
+	name := strings.ToLower(strings.Join(strings.Fields(strings.ReplaceAll(*truncation, "_", " ")), " "))
+	for _, known := range dateTimeTruncations {
+		if name == known {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("unknown date-time truncation: %s", *truncation)
+}
+
+func truncateDateTime(dateTime time.Time, truncation string) time.Time {
+	year, month, day := dateTime.Date()
+	location := dateTime.Location()
+	switch truncation {
+	case truncateFirstMinuteOfHour:
+		return time.Date(year, month, day, dateTime.Hour(), 0, 0, 0, location)
+	case truncateFirstHourOfDay:
+		return time.Date(year, month, day, 0, 0, 0, 0, location)
+	case truncateFirstDayOfMonth:
+		return time.Date(year, month, 1, 0, 0, 0, 0, location)
+	case truncateFirstDayOfNextMonth:
+		return time.Date(year, month+1, 1, 0, 0, 0, 0, location)
+	case truncateLastDayOfMonth:
+		return time.Date(year, month+1, 0, 0, 0, 0, 0, location)
+	case truncateFirstDayOfYear:
+		return time.Date(year, time.January, 1, 0, 0, 0, 0, location)
+	case truncateFirstDayOfNextYear:
+		return time.Date(year+1, time.January, 1, 0, 0, 0, 0, location)
+	case truncateLastDayOfYear:
+		return time.Date(year, time.December, 31, 0, 0, 0, 0, location)
+	}
+	return dateTime
+}
+
+// parseActualDateTime reads the actual value by a Go layout, or as seconds
+// ("unix") or milliseconds ("epoch") since the Unix epoch.
+func parseActualDateTime(format string, str string) (time.Time, error) {
+	switch strings.ToLower(format) {
+	case dateTimeFormatUnix:
+		seconds, err := strconv.ParseInt(strings.TrimSpace(str), 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(seconds, 0).UTC(), nil
+	case dateTimeFormatEpoch:
+		milliseconds, err := strconv.ParseInt(strings.TrimSpace(str), 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.UnixMilli(milliseconds).UTC(), nil
+	}
+	return time.Parse(format, str)
+}
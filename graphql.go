@@ -0,0 +1,636 @@
+package wiregock
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type graphQLTokenKind int
+
+const (
+	graphQLEOF graphQLTokenKind = iota
+	graphQLPunctuator
+	graphQLName
+	graphQLNumber
+	graphQLString
+)
+
+type graphQLToken struct {
+	kind  graphQLTokenKind
+	value string
+}
+
+type graphQLParser struct {
+	tokens   []graphQLToken
+	position int
+}
+
+// graphQLSelection is a field (name is set), a fragment spread (spread is
+// set) or an inline fragment. Arguments, values and directives are kept in
+// their canonical form.
+type graphQLSelection struct {
+	alias         string
+	name          string
+	arguments     string
+	directives    string
+	spread        string
+	typeCondition string
+	selections    []graphQLSelection
+}
+
+type graphQLOperation struct {
+	head       string
+	selections []graphQLSelection
+}
+
+type graphQLFragment struct {
+	typeCondition string
+	directives    string
+	selections    []graphQLSelection
+}
+
+type graphQLDocument struct {
+	operations []graphQLOperation
+	fragments  map[string]graphQLFragment
+}
+
+// graphQLNode is a printed selection: selections with the same head are
+// merged into one node.
+type graphQLNode struct {
+	head     string
+	children []*graphQLNode
+}
+
+// normalizeGraphQL parses a GraphQL document and prints it in a canonical
+// form: no comments, commas or extra whitespace, sorted arguments, object
+// fields, variables and selections, inlined fragment spreads and merged
+// repeated fields. Queries that differ only in these respects normalize to
+// the same string.
+func normalizeGraphQL(query string) (string, error) {
+	tokens, err := tokenizeGraphQL(query)
+	if err != nil {
+		return "", err
+	}
+	parser := graphQLParser{tokens: tokens}
+	document, err := parser.parseDocument()
+	if err != nil {
+		return "", err
+	}
+	return document.print()
+}
+
+func tokenizeGraphQL(query string) ([]graphQLToken, error) {
+	tokens := []graphQLToken{}
+	for index := 0; index < len(query); {
+		char := query[index]
+		switch {
+		case char == ' ' || char == '\t' || char == '\n' || char == '\r' || char == ',':
+			index++
+		case strings.HasPrefix(query[index:], "\ufeff"):
+			index += len("\ufeff")
+		case char == '#':
+			for index < len(query) && query[index] != '\n' && query[index] != '\r' {
+				index++
+			}
+		case strings.HasPrefix(query[index:], "..."):
+			tokens = append(tokens, graphQLToken{graphQLPunctuator, "..."})
+			index += 3
+		case strings.IndexByte("!$&()=:@[]{}|", char) >= 0:
+			tokens = append(tokens, graphQLToken{graphQLPunctuator, string(char)})
+			index++
+		case char == '_' || isGraphQLLetter(char):
+			start := index
+			for index < len(query) && (query[index] == '_' || isGraphQLLetter(query[index]) || isGraphQLDigit(query[index])) {
+				index++
+			}
+			tokens = append(tokens, graphQLToken{graphQLName, query[start:index]})
+		case char == '-' || isGraphQLDigit(char):
+			start := index
+			index++
+			for index < len(query) && (isGraphQLDigit(query[index]) || strings.IndexByte(".eE", query[index]) >= 0 ||
+				((query[index] == '+' || query[index] == '-') && (query[index-1] == 'e' || query[index-1] == 'E'))) {
+				index++
+			}
+			number := query[start:index]
+			if _, err := strconv.ParseFloat(number, 64); err != nil {
+				return nil, fmt.Errorf("invalid GraphQL number: %s", number)
+			}
+			tokens = append(tokens, graphQLToken{graphQLNumber, number})
+		case strings.HasPrefix(query[index:], `"""`):
+			end := index + 3
+			for end < len(query) && !strings.HasPrefix(query[end:], `"""`) {
+				if strings.HasPrefix(query[end:], `\"""`) {
+					end += 4
+				} else {
+					end++
+				}
+			}
+			if end >= len(query) {
+				return nil, errors.New("unterminated GraphQL block string")
+			}
+			value := strings.ReplaceAll(query[index+3:end], `\"""`, `"""`)
+			tokens = append(tokens, graphQLToken{graphQLString, blockStringValue(value)})
+			index = end + 3
+		case char == '"':
+			end := index + 1
+			for end < len(query) && query[end] != '"' && query[end] != '\n' {
+				if query[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(query) || query[end] != '"' {
+				return nil, errors.New("unterminated GraphQL string")
+			}
+			value, err := strconv.Unquote(strings.ReplaceAll(query[index:end+1], `\/`, "/"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid GraphQL string %s: %w", query[index:end+1], err)
+			}
+			tokens = append(tokens, graphQLToken{graphQLString, value})
+			index = end + 1
+		default:
+			unexpected, _ := utf8.DecodeRuneInString(query[index:])
+			return nil, fmt.Errorf("unexpected character %q in GraphQL", unexpected)
+		}
+	}
+	return append(tokens, graphQLToken{kind: graphQLEOF}), nil
+}
+
+func isGraphQLLetter(char byte) bool {
+	return (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z')
+}
+
+func isGraphQLDigit(char byte) bool {
+	return char >= '0' && char <= '9'
+}
+
+// blockStringValue removes the common indentation and the blank first and
+// last lines of a block string.
+func blockStringValue(raw string) string {
+	lines := strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n")
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && (indent < 0 || len(line)-len(trimmed) < indent) {
+			indent = len(line) - len(trimmed)
+		}
+	}
+	for index := 1; indent > 0 && index < len(lines); index++ {
+		if len(lines[index]) >= indent {
+			lines[index] = lines[index][indent:]
+		} else {
+			lines[index] = ""
+		}
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (parser *graphQLParser) peek() graphQLToken {
+	return parser.tokens[parser.position]
+}
+
+func (parser *graphQLParser) next() graphQLToken {
+	token := parser.tokens[parser.position]
+	if token.kind != graphQLEOF {
+		parser.position++
+	}
+	return token
+}
+
+func (parser *graphQLParser) is(kind graphQLTokenKind, value string) bool {
+	token := parser.peek()
+	return token.kind == kind && token.value == value
+}
+
+func (parser *graphQLParser) skip(value string) bool {
+	if parser.is(graphQLPunctuator, value) {
+		parser.position++
+		return true
+	}
+	return false
+}
+
+func (parser *graphQLParser) expect(value string) error {
+	if !parser.skip(value) {
+		return parser.unexpected()
+	}
+	return nil
+}
+
+func (parser *graphQLParser) expectName() (string, error) {
+	if parser.peek().kind != graphQLName {
+		return "", parser.unexpected()
+	}
+	return parser.next().value, nil
+}
+
+func (parser *graphQLParser) unexpected() error {
+	token := parser.peek()
+	if token.kind == graphQLEOF {
+		return errors.New("unexpected end of GraphQL document")
+	}
+	return fmt.Errorf("unexpected %q in GraphQL document", token.value)
+}
+
+func (parser *graphQLParser) parseDocument() (*graphQLDocument, error) {
+	document := graphQLDocument{fragments: make(map[string]graphQLFragment)}
+	for parser.peek().kind != graphQLEOF {
+		if parser.is(graphQLName, "fragment") {
+			parser.next()
+			name, err := parser.expectName()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := document.fragments[name]; ok || name == "on" {
+				return nil, fmt.Errorf("invalid GraphQL fragment name: %s", name)
+			}
+			fragment, err := parser.parseFragment()
+			if err != nil {
+				return nil, err
+			}
+			document.fragments[name] = *fragment
+			continue
+		}
+		operation, err := parser.parseOperation()
+		if err != nil {
+			return nil, err
+		}
+		document.operations = append(document.operations, *operation)
+	}
+	if len(document.operations) == 0 {
+		return nil, errors.New("GraphQL document has no operations")
+	}
+	return &document, nil
+}
+
+func (parser *graphQLParser) parseFragment() (*graphQLFragment, error) {
+	if !parser.is(graphQLName, "on") {
+		return nil, parser.unexpected()
+	}
+	parser.next()
+	typeCondition, err := parser.expectName()
+	if err != nil {
+		return nil, err
+	}
+	directives, err := parser.parseDirectives(false)
+	if err != nil {
+		return nil, err
+	}
+	selections, err := parser.parseSelectionSet()
+	if err != nil {
+		return nil, err
+	}
+	return &graphQLFragment{typeCondition, directives, selections}, nil
+}
+
+// parseOperation reads an operation; the shorthand { ... } is a query.
+func (parser *graphQLParser) parseOperation() (*graphQLOperation, error) {
+	head := "query"
+	if !parser.is(graphQLPunctuator, "{") {
+		kind, err := parser.expectName()
+		if err != nil {
+			return nil, err
+		}
+		if kind != "query" && kind != "mutation" && kind != "subscription" {
+			return nil, fmt.Errorf("unknown GraphQL operation: %s", kind)
+		}
+		head = kind
+		if parser.peek().kind == graphQLName {
+			head += " " + parser.next().value
+		}
+		if parser.is(graphQLPunctuator, "(") {
+			variables, err := parser.parseVariableDefinitions()
+			if err != nil {
+				return nil, err
+			}
+			head += variables
+		}
+		directives, err := parser.parseDirectives(false)
+		if err != nil {
+			return nil, err
+		}
+		head += directives
+	}
+	selections, err := parser.parseSelectionSet()
+	if err != nil {
+		return nil, err
+	}
+	return &graphQLOperation{head, selections}, nil
+}
+
+func (parser *graphQLParser) parseVariableDefinitions() (string, error) {
+	parser.next()
+	definitions := []string{}
+	for !parser.skip(")") {
+		if err := parser.expect("$"); err != nil {
+			return "", err
+		}
+		name, err := parser.expectName()
+		if err != nil {
+			return "", err
+		}
+		if err := parser.expect(":"); err != nil {
+			return "", err
+		}
+		variableType, err := parser.parseType()
+		if err != nil {
+			return "", err
+		}
+		definition := "$" + name + ":" + variableType
+		if parser.skip("=") {
+			value, err := parser.parseValue(true)
+			if err != nil {
+				return "", err
+			}
+			definition += "=" + value
+		}
+		directives, err := parser.parseDirectives(true)
+		if err != nil {
+			return "", err
+		}
+		definitions = append(definitions, definition+directives)
+	}
+	if len(definitions) == 0 {
+		return "", errors.New("empty GraphQL variable definitions")
+	}
+	slices.Sort(definitions)
+	return "(" + strings.Join(definitions, ",") + ")", nil
+}
+
+func (parser *graphQLParser) parseType() (string, error) {
+	var variableType string
+	if parser.skip("[") {
+		itemType, err := parser.parseType()
+		if err != nil {
+			return "", err
+		}
+		if err := parser.expect("]"); err != nil {
+			return "", err
+		}
+		variableType = "[" + itemType + "]"
+	} else {
+		name, err := parser.expectName()
+		if err != nil {
+			return "", err
+		}
+		variableType = name
+	}
+	if parser.skip("!") {
+		variableType += "!"
+	}
+	return variableType, nil
+}
+
+func (parser *graphQLParser) parseDirectives(constant bool) (string, error) {
+	directives := ""
+	for parser.skip("@") {
+		name, err := parser.expectName()
+		if err != nil {
+			return "", err
+		}
+		arguments, err := parser.parseArguments(constant)
+		if err != nil {
+			return "", err
+		}
+		directives += "@" + name + arguments
+	}
+	return directives, nil
+}
+
+func (parser *graphQLParser) parseArguments(constant bool) (string, error) {
+	if !parser.skip("(") {
+		return "", nil
+	}
+	arguments, err := parser.parseFields(")", constant)
+	if err != nil {
+		return "", err
+	}
+	if arguments == "" {
+		return "", errors.New("empty GraphQL arguments")
+	}
+	return "(" + arguments + ")", nil
+}
+
+// parseFields reads name: value pairs up to the closing punctuator and
+// returns them sorted by name.
+func (parser *graphQLParser) parseFields(closing string, constant bool) (string, error) {
+	fields := []string{}
+	names := make(map[string]bool)
+	for !parser.skip(closing) {
+		name, err := parser.expectName()
+		if err != nil {
+			return "", err
+		}
+		if names[name] {
+			return "", fmt.Errorf("GraphQL argument %s is repeated", name)
+		}
+		names[name] = true
+		if err := parser.expect(":"); err != nil {
+			return "", err
+		}
+		value, err := parser.parseValue(constant)
+		if err != nil {
+			return "", err
+		}
+		fields = append(fields, name+":"+value)
+	}
+	slices.Sort(fields)
+	return strings.Join(fields, ","), nil
+}
+
+func (parser *graphQLParser) parseValue(constant bool) (string, error) {
+	token := parser.next()
+	switch token.kind {
+	case graphQLNumber:
+		return token.value, nil
+	case graphQLString:
+		return strconv.Quote(token.value), nil
+	case graphQLName:
+		return token.value, nil
+	case graphQLPunctuator:
+		switch token.value {
+		case "$":
+			if constant {
+				break
+			}
+			name, err := parser.expectName()
+			if err != nil {
+				return "", err
+			}
+			return "$" + name, nil
+		case "[":
+			items := []string{}
+			for !parser.skip("]") {
+				item, err := parser.parseValue(constant)
+				if err != nil {
+					return "", err
+				}
+				items = append(items, item)
+			}
+			return "[" + strings.Join(items, ",") + "]", nil
+		case "{":
+			fields, err := parser.parseFields("}", constant)
+			if err != nil {
+				return "", err
+			}
+			return "{" + fields + "}", nil
+		}
+	}
+	if token.kind != graphQLEOF {
+		parser.position--
+	}
+	return "", parser.unexpected()
+}
+
+func (parser *graphQLParser) parseSelectionSet() ([]graphQLSelection, error) {
+	if err := parser.expect("{"); err != nil {
+		return nil, err
+	}
+	selections := []graphQLSelection{}
+	for !parser.skip("}") {
+		selection, err := parser.parseSelection()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, *selection)
+	}
+	if len(selections) == 0 {
+		return nil, errors.New("empty GraphQL selection set")
+	}
+	return selections, nil
+}
+
+func (parser *graphQLParser) parseSelection() (*graphQLSelection, error) {
+	selection := graphQLSelection{}
+	if parser.skip("...") {
+		if parser.peek().kind == graphQLName && parser.peek().value != "on" {
+			selection.spread = parser.next().value
+			directives, err := parser.parseDirectives(false)
+			selection.directives = directives
+			return &selection, err
+		}
+		if parser.is(graphQLName, "on") {
+			parser.next()
+			typeCondition, err := parser.expectName()
+			if err != nil {
+				return nil, err
+			}
+			selection.typeCondition = typeCondition
+		}
+	} else {
+		name, err := parser.expectName()
+		if err != nil {
+			return nil, err
+		}
+		selection.name = name
+		if parser.skip(":") {
+			selection.alias = name
+			if selection.name, err = parser.expectName(); err != nil {
+				return nil, err
+			}
+		}
+		if selection.arguments, err = parser.parseArguments(false); err != nil {
+			return nil, err
+		}
+	}
+	directives, err := parser.parseDirectives(false)
+	if err != nil {
+		return nil, err
+	}
+	selection.directives = directives
+	if selection.name == "" || parser.is(graphQLPunctuator, "{") {
+		if selection.selections, err = parser.parseSelectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return &selection, nil
+}
+
+func (document *graphQLDocument) print() (string, error) {
+	operations := []string{}
+	for _, operation := range document.operations {
+		root := &graphQLNode{}
+		if err := document.resolve(root, operation.selections, nil); err != nil {
+			return "", err
+		}
+		operations = append(operations, operation.head+root.print())
+	}
+	slices.Sort(operations)
+	return strings.Join(operations, " "), nil
+}
+
+// resolve adds the selections to the node. A fragment spread is written as
+// the inline fragment with its type condition, and an inline fragment
+// without a type condition or directives is merged into the node.
+func (document *graphQLDocument) resolve(node *graphQLNode, selections []graphQLSelection, expanding []string) error {
+	for _, selection := range selections {
+		switch {
+		case selection.spread != "":
+			fragment, ok := document.fragments[selection.spread]
+			if !ok {
+				return fmt.Errorf("unknown GraphQL fragment: %s", selection.spread)
+			}
+			if slices.Contains(expanding, selection.spread) {
+				return fmt.Errorf("GraphQL fragment %s spreads itself", selection.spread)
+			}
+			target := node.child("...on " + fragment.typeCondition + selection.directives + fragment.directives)
+			if err := document.resolve(target, fragment.selections, append(expanding, selection.spread)); err != nil {
+				return err
+			}
+		case selection.name == "":
+			target := node
+			if selection.typeCondition != "" || selection.directives != "" {
+				head := "..."
+				if selection.typeCondition != "" {
+					head += "on " + selection.typeCondition
+				}
+				target = node.child(head + selection.directives)
+			}
+			if err := document.resolve(target, selection.selections, expanding); err != nil {
+				return err
+			}
+		default:
+			head := selection.name + selection.arguments + selection.directives
+			if selection.alias != "" && selection.alias != selection.name {
+				head = selection.alias + ":" + head
+			}
+			if err := document.resolve(node.child(head), selection.selections, expanding); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (node *graphQLNode) child(head string) *graphQLNode {
+	for _, child := range node.children {
+		if child.head == head {
+			return child
+		}
+	}
+	child := &graphQLNode{head: head}
+	node.children = append(node.children, child)
+	return child
+}
+
+func (node *graphQLNode) print() string {
+	if len(node.children) == 0 {
+		return ""
+	}
+	children := make([]string, len(node.children))
+	for index, child := range node.children {
+		children[index] = child.head + child.print()
+	}
+	slices.Sort(children)
+	return "{" + strings.Join(children, " ") + "}"
+}
@@ -0,0 +1,139 @@
+package wiregock
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestNormalizeGraphQL(t *testing.T) {
+	equivalent := [][]string{
+		{
+			`{ hero { name id } }`,
+			`query {
+				# the hero
+				hero { id, name }
+			}`,
+			`query { hero { id } hero { name } }`,
+		},
+		{
+			`query Hero($episode: Episode = JEDI, $withFriends: Boolean!) { hero(episode: $episode, first: 10) { ... on Character { name } friends @include(if: $withFriends) { ... on Character { name } } } }`,
+			`query Hero($withFriends: Boolean!, $episode: Episode = JEDI) {
+				hero(first: 10, episode: $episode) {
+					...HeroName
+					friends @include(if: $withFriends) { ...HeroName }
+				}
+			}
+			fragment HeroName on Character { name }`,
+		},
+		{
+			`mutation { create(input: {name: "R2", tags: ["a", "b"], meta: {b: 1, a: 2.5}}) { id } }`,
+			`mutation { create(input: {tags: ["a" "b"], meta: {a: 2.5, b: 1}, name: """
+				R2
+			"""}) { id } }`,
+		},
+		{
+			`{ search { ... on Droid { primaryFunction } ... on Human { height } } }`,
+			`{ search { ...on Human { height } ...on Droid { primaryFunction } } }`,
+		},
+		{
+			`{ hero { ... { name } } }`,
+			`{ hero { name } }`,
+		},
+		{
+			`{ node { ... on User { name } } }`,
+			`{ node { ...F } } fragment F on User { name }`,
+		},
+		{
+			`{ node { ... on User @skip(if: false) { name } } }`,
+			`{ node { ...F @skip(if: false) } } fragment F on User { name }`,
+		},
+	}
+	for _, queries := range equivalent {
+		expected, err := normalizeGraphQL(queries[0])
+		if err != nil {
+			t.Fatalf(`Error normalizing %s: %s`, queries[0], err)
+		}
+		for _, query := range queries[1:] {
+			normalized, err := normalizeGraphQL(query)
+			if err != nil {
+				t.Fatalf(`Error normalizing %s: %s`, query, err)
+			}
+			if normalized != expected {
+				t.Fatalf(`%s is normalized to %s instead of %s`, query, normalized, expected)
+			}
+		}
+	}
+	different := [][]string{
+		{`{ hero { name } }`, `{ hero { id } }`},
+		{`{ hero(id: 1) { name } }`, `{ hero(id: 2) { name } }`},
+		{`{ a: hero { name } }`, `{ hero { name } }`},
+		{`query { hero { name } }`, `mutation { hero { name } }`},
+		{`{ hero(tags: ["a", "b"]) { name } }`, `{ hero(tags: ["b", "a"]) { name } }`},
+		{`{ node { ...F } } fragment F on User { name }`, `{ node { name } }`},
+	}
+	for _, queries := range different {
+		first, _ := normalizeGraphQL(queries[0])
+		second, _ := normalizeGraphQL(queries[1])
+		if first == second {
+			t.Fatalf(`%s and %s are normalized to the same %s`, queries[0], queries[1], first)
+		}
+	}
+	for _, invalid := range []string{
+		``,
+		`{ hero { name }`,
+		`{ hero { } }`,
+		`{ hero { ...Missing } }`,
+		`{ hero { ...A } } fragment A on Hero { ...A }`,
+		`{ hero(id: ) { name } }`,
+		`{ hero(name: "unterminated) { name } }`,
+		`subscribe { hero }`,
+		`{ hero ? }`,
+	} {
+		if normalized, err := normalizeGraphQL(invalid); err == nil {
+			t.Fatalf(`Invalid GraphQL %s is normalized to %s`, invalid, normalized)
+		}
+	}
+}
+
+func TestGraphQLRule(t *testing.T) {
+	body := `{"query": "query Hero($id: ID!) { hero(id: $id) { id, name } }", "operationName": "Hero", "variables": {"id": "1000", "page": 2}}`
+	valuesExpected := []struct {
+		filter   string
+		value    string
+		expected bool
+	}{
+		{`{"equalToGraphQL": "query Hero($id: ID!) { hero(id: $id) { name id } }"}`, body, true},
+		{`{"equalToGraphQL": "query Hero($id: ID!) { hero(id: $id) { name } }"}`, body, false},
+		{`{"equalToGraphQL": "{ hero { name } }"}`, `{ hero { name } }`, true},
+		{`{"equalToGraphQL": "{ hero { name } }"}`, `{"variables": {}}`, false},
+		{`{"equalToGraphQL": "{ hero { name } }"}`, `[1]`, false},
+		{`{"matchesGraphQL": {"operationName": "Hero"}}`, body, true},
+		{`{"matchesGraphQL": {"operationName": {"matches": "Villain.*"}}}`, body, false},
+		{`{"matchesGraphQL": {"variables": {"equalToJson": "{\"page\": 2, \"id\": \"1000\"}"}}}`, body, true},
+		{`{"matchesGraphQL": {"variables": {"matchesJsonPath": {"expression": "$.page", "greaterThan": 1}}}}`, body, true},
+		{`{"matchesGraphQL": {"variables": {"matchesJsonPath": "$.missing"}}}`, body, false},
+		{`{"matchesGraphQL": {"query": "query Hero($id: ID!) { hero(id: $id) { name id } }", "operationName": "Hero", "variables": {"contains": "1000"}}}`, body, true},
+		{`{"matchesGraphQL": {"variables": {"absent": true}}}`, `{"query": "{ hero { name } }"}`, true},
+		{`{"matchesGraphQL": {"variables": {"equalToJson": "{}"}}}`, `{"query": "{ hero { name } }"}`, false},
+		{`{"matchesGraphQL": {"variables": {"matchesJsonPath": "$.id"}}}`, `{"query": "{ hero { name } }", "variables": null}`, false},
+		{`{"matchesGraphQL": {"variables": {"equalToXml": "<a/>"}}}`, `{ hero { name } }`, false},
+	}
+	for _, valueExpected := range valuesExpected {
+		var filter Filter
+		if err := json.Unmarshal([]byte(valueExpected.filter), &filter); err != nil {
+			t.Fatalf(`Error parsing %s: %s`, valueExpected.filter, err)
+		}
+		rule, err := NewRule(&filter)
+		if err != nil {
+			t.Fatalf(`Error creating rule for %s: %s`, valueExpected.filter, err)
+		}
+		res, err := rule.Check(valueExpected.value)
+		if err != nil || res != valueExpected.expected {
+			t.Fatalf(`Wrong %s matching of %s: expected %t, got %t. Error: %s`, valueExpected.filter, valueExpected.value, valueExpected.expected, res, err)
+		}
+	}
+	invalid := "{ hero {"
+	if _, err := NewRule(&Filter{EqualToGraphQL: &invalid}); err == nil {
+		t.Fatalf(`Invalid GraphQL query accepted`)
+	}
+}
@@ -0,0 +1,282 @@
+package wiregock
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"regexp"
+	"strings"
+
+	"github.com/PaesslerAG/gval"
+	"github.com/PaesslerAG/jsonpath"
+)
+
+const (
+	jsonPlaceholderAnyString     = "${json-unit.any-string}"
+	jsonPlaceholderAnyNumber     = "${json-unit.any-number}"
+	jsonPlaceholderAnyBoolean    = "${json-unit.any-boolean}"
+	jsonPlaceholderIgnore        = "${json-unit.ignore}"
+	jsonPlaceholderIgnoreElement = "${json-unit.ignore-element}"
+	jsonPlaceholderRegexPrefix   = "${json-unit.regex}"
+)
+
+// jsonPathLanguage adds comparison and logic operators to JSON path filters,
+// e.g. $.items[?(@.price > 10 && @.stock)].
+var jsonPathLanguage = gval.Full(jsonpath.Language())
+
+func compileJsonPath(path string) (gval.Evaluable, error) {
+	return jsonPathLanguage.NewEvaluable(path)
+}
+
+type jsonPlaceholder struct {
+	kind  string
+	regex *regexp.Regexp
+}
+
+func parseJson(str string) (interface{}, error) {
+	decoder := json.NewDecoder(strings.NewReader(str))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after JSON value")
+	}
+	return value, nil
+}
+
+// jsonValueString returns strings as they are and other values as compact
+// JSON with sorted object keys.
+func jsonValueString(value interface{}) (string, error) {
+	if str, ok := value.(string); ok {
+		return str, nil
+	}
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buffer.String(), "\n"), nil
+}
+
+// isDefiniteJsonPath reports whether a JSON path selects at most one value,
+// i.e. it has no wildcards, deep scans, filters, unions or slices.
+func isDefiniteJsonPath(path string) bool {
+	if strings.Contains(path, "..") || strings.Contains(path, "*") {
+		return false
+	}
+	inBrackets := false
+	for _, char := range path {
+		switch {
+		case char == '[':
+			inBrackets = true
+		case char == ']':
+			inBrackets = false
+		case inBrackets && (char == '?' || char == ',' || char == ':'):
+			return false
+		}
+	}
+	return true
+}
+
+// loadJsonPlaceholders replaces json-unit placeholder strings of an expected
+// value with jsonPlaceholder matchers, compiling regexes once.
+func loadJsonPlaceholders(value interface{}) (interface{}, error) {
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		for key, item := range typedValue {
+			loaded, err := loadJsonPlaceholders(item)
+			if err != nil {
+				return nil, err
+			}
+			typedValue[key] = loaded
+		}
+	case []interface{}:
+		for index, item := range typedValue {
+			loaded, err := loadJsonPlaceholders(item)
+			if err != nil {
+				return nil, err
+			}
+			typedValue[index] = loaded
+		}
+	case string:
+		switch typedValue {
+		case jsonPlaceholderAnyString, jsonPlaceholderAnyNumber, jsonPlaceholderAnyBoolean, jsonPlaceholderIgnore, jsonPlaceholderIgnoreElement:
+			return jsonPlaceholder{kind: typedValue}, nil
+		}
+		if strings.HasPrefix(typedValue, jsonPlaceholderRegexPrefix) {
+			regexStr := strings.TrimPrefix(typedValue, jsonPlaceholderRegexPrefix)
+			regex, err := regexp.Compile("^(?:" + regexStr + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s placeholder: %w", jsonPlaceholderRegexPrefix, err)
+			}
+			return jsonPlaceholder{kind: jsonPlaceholderRegexPrefix, regex: regex}, nil
+		}
+	}
+	return value, nil
+}
+
+func (placeholder jsonPlaceholder) matches(actual interface{}) bool {
+	switch placeholder.kind {
+	case jsonPlaceholderAnyString:
+		_, ok := actual.(string)
+		return ok
+	case jsonPlaceholderAnyNumber:
+		_, ok := actual.(json.Number)
+		return ok
+	case jsonPlaceholderAnyBoolean:
+		_, ok := actual.(bool)
+		return ok
+	case jsonPlaceholderRegexPrefix:
+		actualValue, ok := actual.(string)
+		return ok && placeholder.regex.MatchString(actualValue)
+	}
+	return true
+}
+
+func equalJson(expected interface{}, actual interface{}, options EqualToBaseRule) bool {
+	switch expectedValue := expected.(type) {
+	case jsonPlaceholder:
+		return expectedValue.matches(actual)
+	case map[string]interface{}:
+		actualValue, ok := actual.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		return equalJsonObjects(expectedValue, actualValue, options)
+	case []interface{}:
+		actualValue, ok := actual.([]interface{})
+		if !ok {
+			return false
+		}
+		if options.IgnoreArrayOrder {
+			return equalJsonArraysUnordered(expectedValue, actualValue, options)
+		}
+		return equalJsonArrays(expectedValue, actualValue, options)
+	case json.Number:
+		actualValue, ok := actual.(json.Number)
+		if !ok {
+			return false
+		}
+		return equalJsonNumbers(expectedValue, actualValue)
+	case string:
+		actualValue, ok := actual.(string)
+		return ok && expectedValue == actualValue
+	case bool:
+		actualValue, ok := actual.(bool)
+		return ok && expectedValue == actualValue
+	case nil:
+		return actual == nil
+	}
+	return false
+}
+
+func equalJsonObjects(expected map[string]interface{}, actual map[string]interface{}, options EqualToBaseRule) bool {
+	matchedKeys := 0
+	for key, expectedValue := range expected {
+		actualValue, ok := actual[key]
+		if !ok {
+			if isJsonIgnoreElement(expectedValue) {
+				continue
+			}
+			return false
+		}
+		if !equalJson(expectedValue, actualValue, options) {
+			return false
+		}
+		matchedKeys++
+	}
+	return options.IgnoreExtraElements || matchedKeys == len(actual)
+}
+
+func isJsonIgnoreElement(value interface{}) bool {
+	placeholder, ok := value.(jsonPlaceholder)
+	return ok && placeholder.kind == jsonPlaceholderIgnoreElement
+}
+
+func equalJsonArrays(expected []interface{}, actual []interface{}, options EqualToBaseRule) bool {
+	if !jsonArrayLengthMatches(expected, actual, options) {
+		return false
+	}
+	for index, expectedValue := range expected {
+		if !equalJson(expectedValue, actual[index], options) {
+			return false
+		}
+	}
+	return true
+}
+
+// equalJsonArraysUnordered looks for a one-to-one assignment of expected items
+// to actual items. A greedy pass isn't enough: with ignoreExtraElements
+// one expected item may match several actual ones.
+func equalJsonArraysUnordered(expected []interface{}, actual []interface{}, options EqualToBaseRule) bool {
+	if !jsonArrayLengthMatches(expected, actual, options) {
+		return false
+	}
+	return matchAll(len(expected), len(actual), func(expectedIndex int, actualIndex int) bool {
+		return equalJson(expected[expectedIndex], actual[actualIndex], options)
+	})
+}
+
+func jsonArrayLengthMatches(expected []interface{}, actual []interface{}, options EqualToBaseRule) bool {
+	if options.IgnoreExtraElements {
+		return len(expected) <= len(actual)
+	}
+	return len(expected) == len(actual)
+}
+
+func equalJsonNumbers(expected json.Number, actual json.Number) bool {
+	expectedRat, ok := new(big.Rat).SetString(expected.String())
+	if !ok {
+		return false
+	}
+	actualRat, ok := new(big.Rat).SetString(actual.String())
+	if !ok {
+		return false
+	}
+	return expectedRat.Cmp(actualRat) == 0
+}
+
+// matchAll reports whether every left item can be paired with its own right
+// item, using augmenting paths (Kuhn's algorithm).
+func matchAll(leftCount int, rightCount int, matches func(left int, right int) bool) bool {
+	cache := make(map[[2]int]bool)
+	isMatch := func(left int, right int) bool {
+		key := [2]int{left, right}
+		res, ok := cache[key]
+		if !ok {
+			res = matches(left, right)
+			cache[key] = res
+		}
+		return res
+	}
+	rightOwner := make([]int, rightCount)
+	for index := range rightOwner {
+		rightOwner[index] = -1
+	}
+	var assign func(left int, visited []bool) bool
+	assign = func(left int, visited []bool) bool {
+		for right := 0; right < rightCount; right++ {
+			if visited[right] || !isMatch(left, right) {
+				continue
+			}
+			visited[right] = true
+			if rightOwner[right] < 0 || assign(rightOwner[right], visited) {
+				rightOwner[right] = left
+				return true
+			}
+		}
+		return false
+	}
+	for left := 0; left < leftCount; left++ {
+		if !assign(left, make([]bool, rightCount)) {
+			return false
+		}
+	}
+	return true
+}
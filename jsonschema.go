@@ -0,0 +1,133 @@
+package wiregock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+const jsonSchemaStubName = ".wiregock-stub.json"
+
+var jsonSchemaDirectory struct {
+	sync.RWMutex
+	base    string
+	schemas map[string]string
+}
+
+// LoadJsonSchemaDirectory reads every *.json file of dir, so $ref in
+// matchesJsonSchema can point to them by a path relative to dir or by $id.
+// It has to be called before the stubs are parsed.
+func LoadJsonSchemaDirectory(dir string) error {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	base := "file://" + filepath.ToSlash(absDir) + "/"
+	schemas := make(map[string]string)
+	err = filepath.WalkDir(absDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(path), ".json") {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		schemas["file://"+filepath.ToSlash(path)] = string(data)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	jsonSchemaDirectory.Lock()
+	defer jsonSchemaDirectory.Unlock()
+	jsonSchemaDirectory.base = base
+	jsonSchemaDirectory.schemas = schemas
+	return nil
+}
+
+func loadJsonSchemaDraft(version *string) (*jsonschema.Draft, error) {
+	if version == nil {
+		return jsonschema.Draft2020, nil
+	}
+	name := strings.ToUpper(strings.NewReplacer("-", "", "_", "", " ", "").Replace(*version))
+	name = strings.TrimPrefix(strings.TrimPrefix(name, "V"), "DRAFT")
+	switch strings.TrimLeft(name, "0") {
+	case "4":
+		return jsonschema.Draft4, nil
+	case "6":
+		return jsonschema.Draft6, nil
+	case "7":
+		return jsonschema.Draft7, nil
+	case "201909":
+		return jsonschema.Draft2019, nil
+	case "202012":
+		return jsonschema.Draft2020, nil
+	}
+	return nil, fmt.Errorf("unknown schemaVersion: %s", *version)
+}
+
+// compileJsonSchema compiles the schema for the given draft, which only
+// applies to schemas without $schema. A $ref outside of the schema directory
+// isn't loaded.
+func compileJsonSchema(schema string, version *string) (*jsonschema.Schema, error) {
+	draft, err := loadJsonSchemaDraft(version)
+	if err != nil {
+		return nil, err
+	}
+	compiler := jsonschema.NewCompiler()
+	compiler.Draft = draft
+	compiler.AssertFormat = true
+	compiler.LoadURL = func(url string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("$ref %s isn't in the JSON schema directory", url)
+	}
+	jsonSchemaDirectory.RLock()
+	defer jsonSchemaDirectory.RUnlock()
+	base := jsonSchemaDirectory.base
+	if base == "" {
+		base = "file:///"
+	}
+	for url, data := range jsonSchemaDirectory.schemas {
+		if err := addJsonSchema(compiler, url, data); err != nil {
+			return nil, err
+		}
+	}
+	stubUrl := base + jsonSchemaStubName
+	if err := compiler.AddResource(stubUrl, strings.NewReader(schema)); err != nil {
+		return nil, err
+	}
+	return compiler.Compile(stubUrl)
+}
+
+// addJsonSchema adds a schema of the directory by its file URL and by its
+// $id, so other schemas can refer to it either way.
+func addJsonSchema(compiler *jsonschema.Compiler, url string, data string) error {
+	if err := compiler.AddResource(url, strings.NewReader(data)); err != nil {
+		return fmt.Errorf("invalid JSON schema %s: %w", url, err)
+	}
+	var ids struct {
+		Id       string `json:"$id"`
+		IdDraft4 string `json:"id"`
+	}
+	if err := json.Unmarshal([]byte(data), &ids); err != nil {
+		return nil
+	}
+	for _, id := range []string{ids.Id, ids.IdDraft4} {
+		id, _, _ = strings.Cut(id, "#")
+		if id != "" && id != url {
+			if err := compiler.AddResource(id, strings.NewReader(data)); err != nil {
+				return fmt.Errorf("invalid JSON schema %s: %w", url, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+package wiregock
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+)
+
+type jwtToken struct {
+	header       string
+	payload      string
+	alg          string
+	kid          string
+	signingInput []byte
+	signature    []byte
+}
+
+type jwtKey struct {
+	kid string
+	alg string
+	key interface{} // []byte, *rsa.PublicKey or *ecdsa.PublicKey
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJwt splits a compact JWT, optionally prefixed with "Bearer", and
+// decodes its header and payload, which have to be JSON objects.
+func parseJwt(str string) (*jwtToken, error) {
+	str = strings.TrimSpace(str)
+	if scheme, token, ok := strings.Cut(str, " "); ok && strings.EqualFold(scheme, "Bearer") {
+		str = strings.TrimSpace(token)
+	}
+	parts := strings.Split(str, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("JWT must have three parts")
+	}
+	header, err := decodeJwtPart(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	payload, err := decodeJwtPart(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[2], "="))
+	if err != nil {
+		return nil, err
+	}
+	var fields struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(header, &fields); err != nil {
+		return nil, err
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, err
+	}
+	return &jwtToken{
+		header:       string(header),
+		payload:      string(payload),
+		alg:          fields.Alg,
+		kid:          fields.Kid,
+		signingInput: []byte(parts[0] + "." + parts[1]),
+		signature:    signature,
+	}, nil
+}
+
+func decodeJwtPart(part string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(part, "="))
+}
+
+// loadJwtKeys collects the keys a matchesJwt verifies signatures with: the
+// HMAC secret and the keys of a local JWKS file.
+func loadJwtKeys(jwtFilter *JwtFilter) ([]jwtKey, error) {
+	keys := []jwtKey{}
+	if jwtFilter.Secret != nil {
+		keys = append(keys, jwtKey{key: []byte(*jwtFilter.Secret)})
+	}
+	if jwtFilter.JwksFile != nil {
+		data, err := os.ReadFile(*jwtFilter.JwksFile)
+		if err != nil {
+			return nil, err
+		}
+		jwksKeys, err := parseJwks(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS %s: %w", *jwtFilter.JwksFile, err)
+		}
+		keys = append(keys, jwksKeys...)
+	}
+	return keys, nil
+}
+
+func parseJwks(data []byte) ([]jwtKey, error) {
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, err
+	}
+	keys := []jwtKey{}
+	for index, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %w", index, err)
+		}
+		keys = append(keys, jwtKey{kid: jwk.Kid, alg: jwk.Alg, key: key})
+	}
+	return keys, nil
+}
+
+func (jwk jsonWebKey) publicKey() (interface{}, error) {
+	switch jwk.Kty {
+	case "oct":
+		return base64.RawURLEncoding.DecodeString(jwk.K)
+	case "RSA":
+		n, err := decodeJwkInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeJwkInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 2 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve: %s", jwk.Crv)
+		}
+		x, err := decodeJwkInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeJwkInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type: %s", jwk.Kty)
+}
+
+func decodeJwkInt(str string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(str)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// verifyJwt reports whether one of the keys signed the token. Unsigned
+// tokens ("alg": "none") never pass.
+func verifyJwt(token *jwtToken, keys []jwtKey) bool {
+	hash, ok := jwtHash(token.alg)
+	if !ok {
+		return false
+	}
+	for _, key := range keys {
+		if key.kid != "" && token.kid != "" && key.kid != token.kid {
+			continue
+		}
+		if key.alg != "" && key.alg != token.alg {
+			continue
+		}
+		if verifyJwtSignature(token, hash, key.key) {
+			return true
+		}
+	}
+	return false
+}
+
+func jwtHash(alg string) (crypto.Hash, bool) {
+	if len(alg) != 5 {
+		return 0, false
+	}
+	switch alg[:2] {
+	case "HS", "RS", "PS", "ES":
+	default:
+		return 0, false
+	}
+	switch alg[2:] {
+	case "256":
+		return crypto.SHA256, true
+	case "384":
+		return crypto.SHA384, true
+	case "512":
+		return crypto.SHA512, true
+	}
+	return 0, false
+}
+
+func verifyJwtSignature(token *jwtToken, hash crypto.Hash, key interface{}) bool {
+	if secret, ok := key.([]byte); ok {
+		if !strings.HasPrefix(token.alg, "HS") {
+			return false
+		}
+		mac := hmac.New(hash.New, secret)
+		mac.Write(token.signingInput)
+		return hmac.Equal(mac.Sum(nil), token.signature)
+	}
+	hasher := hash.New()
+	hasher.Write(token.signingInput)
+	digest := hasher.Sum(nil)
+	switch publicKey := key.(type) {
+	case *rsa.PublicKey:
+		switch token.alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(publicKey, hash, digest, token.signature) == nil
+		case "PS":
+			return rsa.VerifyPSS(publicKey, hash, digest, token.signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		}
+	case *ecdsa.PublicKey:
+		size := (publicKey.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(token.alg, "ES") || len(token.signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(token.signature[:size])
+		s := new(big.Int).SetBytes(token.signature[size:])
+		return ecdsa.Verify(publicKey, digest, r, s)
+	}
+	return false
+}
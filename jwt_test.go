@@ -0,0 +1,144 @@
+package wiregock
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func signJwt(t *testing.T, header string, payload string, sign func(signingInput []byte) []byte) string {
+	signingInput := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signingInput)))
+}
+
+func signJwtHmac(secret string) func([]byte) []byte {
+	return func(signingInput []byte) []byte {
+		mac := hmac.New(crypto.SHA256.New, []byte(secret))
+		mac.Write(signingInput)
+		return mac.Sum(nil)
+	}
+}
+
+func signJwtEcdsa(t *testing.T, key *ecdsa.PrivateKey) func([]byte) []byte {
+	return func(signingInput []byte) []byte {
+		digest := sha256.Sum256(signingInput)
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatalf(`Error signing JWT: %s`, err)
+		}
+		signature := make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+		return signature
+	}
+}
+
+func parseJwtFilter(t *testing.T, data string) Rule {
+	var filter Filter
+	if err := json.Unmarshal([]byte(data), &filter); err != nil {
+		t.Fatalf(`Error parsing %s: %s`, data, err)
+	}
+	rule, err := NewRule(&filter)
+	if err != nil {
+		t.Fatalf(`Error creating rule for %s: %s`, data, err)
+	}
+	return rule
+}
+
+func TestJwtRuleClaims(t *testing.T) {
+	token := signJwt(t, `{"alg": "HS256", "typ": "JWT"}`, `{"sub": "alice", "aud": ["api", "web"], "exp": 1900000000, "realm_access": {"roles": ["admin", "user"]}}`, signJwtHmac("other"))
+	valuesExpected := []struct {
+		filter   string
+		value    string
+		expected bool
+	}{
+		{`{"matchesJwt": {"payload": {"sub": "alice"}}}`, "Bearer " + token, true},
+		{`{"matchesJwt": {"payload": {"sub": "alice"}}}`, token, true},
+		{`{"matchesJwt": {"payload": {"sub": "bob"}}}`, token, false},
+		{`{"matchesJwt": {"header": {"alg": "HS256"}, "payload": {"aud": "web"}}}`, token, true},
+		{`{"matchesJwt": {"header": {"alg": {"equalTo": "RS256"}}}}`, token, false},
+		{`{"matchesJwt": {"payload": {"exp": {"greaterThan": 1800000000}}}}`, token, true},
+		{`{"matchesJwt": {"payload": {"$.realm_access.roles": {"equalTo": "admin"}}}}`, token, true},
+		{`{"matchesJwt": {"payload": {"$.realm_access.roles": {"equalTo": "root"}}}}`, token, false},
+		{`{"matchesJwt": {"payload": {"email": {"absent": true}}}}`, token, true},
+		{`{"matchesJwt": {"payload": {"sub": {"absent": true}}}}`, token, false},
+		{`{"matchesJwt": {"payload": {"sub": {}}}}`, token, true},
+		{`{"matchesJwt": {}}`, token, true},
+		{`{"matchesJwt": {}}`, "Bearer not-a-token", false},
+		{`{"matchesJwt": {}}`, "a.b.c", false},
+		{`{"matchesJwt": {"secret": "secret"}}`, token, false},
+		{`{"matchesJwt": {"secret": "other", "payload": {"sub": "alice"}}}`, token, true},
+	}
+	for _, valueExpected := range valuesExpected {
+		rule := parseJwtFilter(t, valueExpected.filter)
+		res, err := rule.Check(valueExpected.value)
+		if err != nil || res != valueExpected.expected {
+			t.Fatalf(`Wrong %s matching of %s: expected %t, got %t. Error: %s`, valueExpected.filter, valueExpected.value, valueExpected.expected, res, err)
+		}
+	}
+	unsigned := signJwt(t, `{"alg": "none"}`, `{"sub": "alice"}`, func([]byte) []byte { return nil })
+	if res, _ := parseJwtFilter(t, `{"matchesJwt": {"secret": "other"}}`).Check(unsigned); res {
+		t.Fatalf(`Unsigned JWT passed verification`)
+	}
+	if res, _ := parseJwtFilter(t, `{"matchesJwt": {"payload": {"sub": "alice"}}}`).Check(unsigned); !res {
+		t.Fatalf(`Unsigned JWT isn't decoded`)
+	}
+}
+
+func TestJwtRuleJwks(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encodeInt := func(value *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(value.FillBytes(make([]byte, 32)))
+	}
+	jwks, err := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "EC", "kid": "k1", "crv": "P-256", "x": encodeInt(key.X), "y": encodeInt(key.Y)},
+		{"kty": "oct", "kid": "k2", "k": base64.RawURLEncoding.EncodeToString([]byte("secret"))},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(jwksFile, jwks, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	jwksFileJson, _ := json.Marshal(jwksFile)
+	rule := parseJwtFilter(t, `{"matchesJwt": {"jwksFile": `+string(jwksFileJson)+`, "payload": {"sub": "alice"}}}`)
+	tokensExpected := []struct {
+		token    string
+		expected bool
+	}{
+		{signJwt(t, `{"alg": "ES256", "kid": "k1"}`, `{"sub": "alice"}`, signJwtEcdsa(t, key)), true},
+		{signJwt(t, `{"alg": "ES256"}`, `{"sub": "alice"}`, signJwtEcdsa(t, key)), true},
+		{signJwt(t, `{"alg": "ES256", "kid": "k1"}`, `{"sub": "bob"}`, signJwtEcdsa(t, key)), false},
+		{signJwt(t, `{"alg": "ES256", "kid": "k1"}`, `{"sub": "alice"}`, signJwtEcdsa(t, otherKey)), false},
+		{signJwt(t, `{"alg": "HS256", "kid": "k2"}`, `{"sub": "alice"}`, signJwtHmac("secret")), true},
+		{signJwt(t, `{"alg": "HS256", "kid": "k1"}`, `{"sub": "alice"}`, signJwtHmac("secret")), false},
+	}
+	for index, tokenExpected := range tokensExpected {
+		res, err := rule.Check(tokenExpected.token)
+		if err != nil || res != tokenExpected.expected {
+			t.Fatalf(`Wrong matching of token #%d: expected %t, got %t. Error: %s`, index, tokenExpected.expected, res, err)
+		}
+	}
+	var filter Filter
+	json.Unmarshal([]byte(`{"matchesJwt": {"jwksFile": "missing.json"}}`), &filter)
+	if _, err := NewRule(&filter); err == nil {
+		t.Fatalf(`Missing JWKS file accepted`)
+	}
+}
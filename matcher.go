@@ -0,0 +1,58 @@
+package wiregock
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// MatcherFactory creates the Rule of a customMatcher from its parameters,
+// decoded from the stub as JSON or BSON values.
+type MatcherFactory func(parameters map[string]interface{}) (Rule, error)
+
+var customMatchers = struct {
+	sync.RWMutex
+	factories map[string]MatcherFactory
+}{factories: make(map[string]MatcherFactory)}
+
+// RegisterMatcher makes a matcher available to stubs as
+// "customMatcher": {"name": name, "parameters": {...}}. It has to be called
+// before the stubs are parsed.
+func RegisterMatcher(name string, factory MatcherFactory) error {
+	if name == "" {
+		return errors.New("custom matcher name is empty")
+	}
+	if factory == nil {
+		return fmt.Errorf("custom matcher %s has no factory", name)
+	}
+	customMatchers.Lock()
+	defer customMatchers.Unlock()
+	if _, ok := customMatchers.factories[name]; ok {
+		return fmt.Errorf("custom matcher %s is already registered", name)
+	}
+	customMatchers.factories[name] = factory
+	return nil
+}
+
+func UnregisterMatcher(name string) {
+	customMatchers.Lock()
+	defer customMatchers.Unlock()
+	delete(customMatchers.factories, name)
+}
+
+func parseCustomMatcher(customMatcher *CustomMatcher) (Rule, error) {
+	customMatchers.RLock()
+	factory, ok := customMatchers.factories[customMatcher.Name]
+	customMatchers.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown custom matcher: %s", customMatcher.Name)
+	}
+	rule, err := factory(customMatcher.Parameters)
+	if err != nil {
+		return nil, fmt.Errorf("custom matcher %s: %w", customMatcher.Name, err)
+	}
+	if rule == nil {
+		return nil, fmt.Errorf("custom matcher %s returned no rule", customMatcher.Name)
+	}
+	return rule, nil
+}
@@ -0,0 +1,72 @@
+package wiregock
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+type minLengthRule struct {
+	length int
+}
+
+func (rule minLengthRule) Check(str string) (bool, error) {
+	return len(str) >= rule.length, nil
+}
+
+func minLengthFactory(parameters map[string]interface{}) (Rule, error) {
+	length, ok := parameters["length"].(float64)
+	if !ok {
+		return nil, errors.New("length is required")
+	}
+	return minLengthRule{int(length)}, nil
+}
+
+func TestCustomMatcher(t *testing.T) {
+	if err := RegisterMatcher("minLength", minLengthFactory); err != nil {
+		t.Fatalf(`Error registering matcher: %s`, err)
+	}
+	t.Cleanup(func() { UnregisterMatcher("minLength") })
+	if err := RegisterMatcher("minLength", minLengthFactory); err == nil {
+		t.Fatalf(`Duplicate matcher registered`)
+	}
+	if err := RegisterMatcher("", minLengthFactory); err == nil {
+		t.Fatalf(`Matcher without name registered`)
+	}
+	valuesExpected := []struct {
+		filter   string
+		value    string
+		expected bool
+	}{
+		{`{"customMatcher": {"name": "minLength", "parameters": {"length": 3}}}`, "abc", true},
+		{`{"customMatcher": {"name": "minLength", "parameters": {"length": 3}}}`, "ab", false},
+		{`{"customMatcher": {"name": "minLength", "parameters": {"length": 3}}, "contains": "x"}`, "abc", false},
+		{`{"matchesJsonPath": {"expression": "$.name", "customMatcher": {"name": "minLength", "parameters": {"length": 4}}}}`, `{"name": "John"}`, true},
+	}
+	for _, valueExpected := range valuesExpected {
+		var filter Filter
+		if err := json.Unmarshal([]byte(valueExpected.filter), &filter); err != nil {
+			t.Fatalf(`Error parsing %s: %s`, valueExpected.filter, err)
+		}
+		rules, err := parseRules(&filter, true)
+		if err != nil {
+			t.Fatalf(`Error creating rule for %s: %s`, valueExpected.filter, err)
+		}
+		res, err := rules.Check(valueExpected.value)
+		if err != nil || res != valueExpected.expected {
+			t.Fatalf(`Wrong %s matching of %s: expected %t, got %t. Error: %s`, valueExpected.filter, valueExpected.value, valueExpected.expected, res, err)
+		}
+	}
+	for _, invalid := range []string{
+		`{"customMatcher": {"name": "unknown"}}`,
+		`{"customMatcher": {"name": "minLength", "parameters": {"length": "3"}}}`,
+	} {
+		var filter Filter
+		if err := json.Unmarshal([]byte(invalid), &filter); err != nil {
+			t.Fatalf(`Error parsing %s: %s`, invalid, err)
+		}
+		if _, err := parseRules(&filter, true); err == nil {
+			t.Fatalf(`Invalid custom matcher %s accepted`, invalid)
+		}
+	}
+}
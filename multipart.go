@@ -0,0 +1,63 @@
+package wiregock
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strings"
+)
+
+// parseMultipart splits a multipart body by the boundary of its Content-Type.
+// The parts of a nested multipart/mixed part are added in its place and take
+// its name unless they have their own.
+func parseMultipart(contentType string, body string) ([]FileFormData, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, errors.New("not a multipart content type: " + mediaType)
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		return nil, errors.New("multipart boundary is missing")
+	}
+	parts := []FileFormData{}
+	reader := multipart.NewReader(strings.NewReader(body), boundary)
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			return parts, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+		formData := FileFormData{
+			Headers: map[string][]string(part.Header),
+			Data:    string(data),
+		}
+		if _, dispositionParams, err := mime.ParseMediaType(part.Header.Get("Content-Disposition")); err == nil {
+			formData.Name = dispositionParams["name"]
+			formData.FileName = dispositionParams["filename"]
+		}
+		if partType, _, err := mime.ParseMediaType(part.Header.Get("Content-Type")); err == nil && strings.HasPrefix(partType, "multipart/") {
+			nestedParts, err := parseMultipart(part.Header.Get("Content-Type"), formData.Data)
+			if err != nil {
+				return nil, err
+			}
+			for _, nestedPart := range nestedParts {
+				if nestedPart.Name == "" {
+					nestedPart.Name = formData.Name
+				}
+				parts = append(parts, nestedPart)
+			}
+			continue
+		}
+		parts = append(parts, formData)
+	}
+}
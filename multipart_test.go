@@ -0,0 +1,202 @@
+package wiregock
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const multipartBody = "--outer\r\n" +
+	"Content-Disposition: form-data; name=\"title\"\r\n" +
+	"\r\n" +
+	"Holiday\r\n" +
+	"--outer\r\n" +
+	"Content-Disposition: form-data; name=\"photo\"; filename=\"photo.png\"\r\n" +
+	"Content-Type: image/png\r\n" +
+	"\r\n" +
+	"\x89PNG\r\n\x1a\n\x00\xff\r\n" +
+	"--outer\r\n" +
+	"Content-Disposition: form-data; name=\"files\"\r\n" +
+	"Content-Type: multipart/mixed; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Disposition: attachment; filename=\"a.txt\"\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"first\r\n" +
+	"--inner\r\n" +
+	"Content-Disposition: attachment; filename=\"b.txt\"\r\n" +
+	"\r\n" +
+	"second\r\n" +
+	"--inner--\r\n" +
+	"\r\n" +
+	"--outer--\r\n"
+
+func TestParseMultipart(t *testing.T) {
+	parts, err := parseMultipart("multipart/form-data; boundary=outer", multipartBody)
+	if err != nil {
+		t.Fatalf(`Error parsing multipart body: %s`, err)
+	}
+	expected := []FileFormData{
+		{Name: "title", Data: "Holiday"},
+		{Name: "photo", FileName: "photo.png", Data: "\x89PNG\r\n\x1a\n\x00\xff"},
+		{Name: "files", FileName: "a.txt", Data: "first"},
+		{Name: "files", FileName: "b.txt", Data: "second"},
+	}
+	if len(parts) != len(expected) {
+		t.Fatalf(`Wrong number of parts: %d`, len(parts))
+	}
+	for index, part := range parts {
+		if part.Name != expected[index].Name || part.FileName != expected[index].FileName || part.Data != expected[index].Data {
+			t.Fatalf(`Wrong part %d: %+v`, index, part)
+		}
+	}
+	if contentType := parts[1].Headers["Content-Type"]; len(contentType) != 1 || contentType[0] != "image/png" {
+		t.Fatalf(`Wrong headers of part 1: %v`, parts[1].Headers)
+	}
+	for _, contentType := range []string{"", "text/plain", "multipart/form-data"} {
+		if _, err := parseMultipart(contentType, multipartBody); err == nil {
+			t.Fatalf(`Content type %q accepted`, contentType)
+		}
+	}
+	if _, err := parseMultipart("multipart/form-data; boundary=outer", "--outer\r\nbroken"); err == nil {
+		t.Fatalf(`Malformed multipart body accepted`)
+	}
+}
+
+func TestParseConditionMultipart(t *testing.T) {
+	var request MockRequest
+	err := json.Unmarshal([]byte(`{
+		"multipartPatterns": [{
+			"headers": {"Content-Disposition": {"contains": "name=\"photo\""}, "Content-Type": {"equalTo": "image/png"}},
+			"bodyPatterns": [{"contains": "PNG"}]
+		}]
+	}`), &request)
+	if err != nil {
+		t.Fatalf(`Error parsing JSON format: %s`, err)
+	}
+	photoBody := "--outer\r\n" +
+		"Content-Disposition: form-data; name=\"photo\"; filename=\"photo.png\"\r\n" +
+		"Content-Type: image/png\r\n" +
+		"\r\n" +
+		"\x89PNG\r\n" +
+		"--outer--\r\n"
+	context := DataContext{
+		Body: func() string { return photoBody },
+		Get:  func(key string) string { return "multipart/form-data; boundary=outer" },
+	}
+	parsedConditions, err := ParseCondition(&request, &context)
+	if err != nil {
+		t.Fatalf(`Error parsing conditions: %s`, err)
+	}
+	if !parsedConditions.IsMultipart {
+		t.Fatalf(`Multipart stub isn't marked as multipart`)
+	}
+	if res, err := parsedConditions.Condition.Check(); err != nil || !res {
+		t.Fatalf(`Parsed multipart body doesn't match. Error: %s`, err)
+	}
+}
+
+func TestFileDataConditionMatchingType(t *testing.T) {
+	valuesExpected := []struct {
+		pattern     string
+		contentType string
+		expected    bool
+	}{
+		{`{"headers": {"Content-Type": {"equalTo": "image/png"}}}`, "multipart/form-data; boundary=outer", true},
+		{`{"matchingType": "ANY", "bodyPatterns": [{"contains": "PNG"}, {"contains": "\u0000"}]}`, "multipart/form-data; boundary=outer", true},
+		{`{"matchingType": "ANY", "bodyPatterns": [{"contains": "PNG"}, {"contains": "first"}]}`, "multipart/form-data; boundary=outer", false},
+		{`{"matchingType": "ALL", "headers": {"Content-Type": {"equalTo": "image/png"}}}`, "multipart/form-data; boundary=outer", false},
+		{`{"matchingType": "ALL", "headers": {"content-disposition": {"contains": "name="}}}`, "multipart/form-data; boundary=outer", true},
+		{`{"matchingType": "ALL", "bodyPatterns": [{"matches": "^[^x]*$"}]}`, "multipart/form-data; boundary=outer", true},
+		{`{"headers": {"X-Missing": {"contains": ""}}}`, "multipart/form-data; boundary=outer", false},
+		{`{"matchingType": "ALL", "headers": {"X-Missing": {"absent": true}}}`, "multipart/form-data; boundary=outer", true},
+		{`{"fileName": {"equalTo": "b.txt"}, "bodyPatterns": [{"equalTo": "second"}]}`, "multipart/form-data; boundary=outer", true},
+		{`{"fileName": {"equalTo": "b.txt"}, "bodyPatterns": [{"equalTo": "first"}]}`, "multipart/form-data; boundary=outer", false},
+		{`{}`, "multipart/form-data; boundary=outer", true},
+		{`{}`, "multipart/form-data; boundary=other", false},
+		{`{"matchingType": "ALL"}`, "application/json", false},
+		{`{"headers": {"X-Missing": {"absent": true}}}`, "application/json", false},
+	}
+	for _, valueExpected := range valuesExpected {
+		var request MockRequest
+		if err := json.Unmarshal([]byte(`{"multipartPatterns": [`+valueExpected.pattern+`]}`), &request); err != nil {
+			t.Fatalf(`Error parsing %s: %s`, valueExpected.pattern, err)
+		}
+		context := DataContext{
+			Body: func() string { return multipartBody },
+			Get:  func(key string) string { return valueExpected.contentType },
+		}
+		parsedConditions, err := ParseCondition(&request, &context)
+		if err != nil {
+			t.Fatalf(`Error parsing conditions of %s: %s`, valueExpected.pattern, err)
+		}
+		res, err := parsedConditions.Condition.Check()
+		if err != nil || res != valueExpected.expected {
+			t.Fatalf(`Wrong %s matching for %s: expected %t, got %t. Error: %s`, valueExpected.pattern, valueExpected.contentType, valueExpected.expected, res, err)
+		}
+	}
+	var request MockRequest
+	json.Unmarshal([]byte(`{"multipartPatterns": [{"matchingType": "SOME"}]}`), &request)
+	if _, err := ParseCondition(&request, &DataContext{}); err == nil {
+		t.Fatalf(`Invalid multipart matchingType accepted`)
+	}
+}
+
+func TestFileDataConditionPartErrors(t *testing.T) {
+	body := "--outer\r\n" +
+		"Content-Disposition: form-data; name=\"photo\"; filename=\"photo.png\"\r\n" +
+		"Content-Type: image/png\r\n" +
+		"\r\n" +
+		"\x89PNG\r\n" +
+		"--outer\r\n" +
+		"Content-Disposition: form-data; name=\"meta\"\r\n" +
+		"Content-Type: application/json\r\n" +
+		"\r\n" +
+		"{\"title\": \"Holiday\"}\r\n" +
+		"--outer--\r\n"
+	for pattern, expected := range map[string]bool{
+		`{"matchingType": "ANY", "bodyPatterns": [{"equalToJson": "{\"title\": \"Holiday\"}"}]}`: true,
+		`{"matchingType": "ALL", "bodyPatterns": [{"equalToJson": "{\"title\": \"Holiday\"}"}]}`: false,
+		`{"matchingType": "ANY", "bodyPatterns": [{"equalToJson": "{\"title\": \"Work\"}"}]}`:    false,
+	} {
+		var request MockRequest
+		if err := json.Unmarshal([]byte(`{"multipartPatterns": [`+pattern+`]}`), &request); err != nil {
+			t.Fatalf(`Error parsing %s: %s`, pattern, err)
+		}
+		context := DataContext{
+			Body: func() string { return body },
+			Get:  func(key string) string { return "multipart/form-data; boundary=outer" },
+		}
+		parsedConditions, err := ParseCondition(&request, &context)
+		if err != nil {
+			t.Fatalf(`Error parsing conditions of %s: %s`, pattern, err)
+		}
+		res, err := parsedConditions.Condition.Check()
+		if err != nil || res != expected {
+			t.Fatalf(`Wrong %s matching: expected %t, got %t. Error: %s`, pattern, expected, res, err)
+		}
+	}
+}
+
+func TestLoadRequestDataParts(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(multipartBody))
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=outer")
+	requestData, err := LoadRequestData(req)
+	if err != nil {
+		t.Fatalf("Error loading request data: %s", err)
+	}
+	parts := (*requestData)["request"].(RequestData)["parts"].(map[string]RequestData)
+	if len(parts) != 3 {
+		t.Fatalf("Wrong number of parts: %v", parts)
+	}
+	photo := parts["photo"]
+	if photo["fileName"] != "photo.png" || photo["headers"].(map[string]string)["Content-Type"] != "image/png" || photo["bodyAsBase64"] != "iVBORw0KGgoA_w==" {
+		t.Fatalf("Wrong photo part: %v", photo)
+	}
+	if parts["title"]["body"] != "Holiday" || parts["files"]["fileName"] != "a.txt" {
+		t.Fatalf("Wrong parts: %v", parts)
+	}
+}
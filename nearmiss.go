@@ -0,0 +1,153 @@
+package wiregock
+
+import (
+	"sort"
+)
+
+const nearMissCompareLimit = 1024
+
+var nearMissWeights = map[string]float64{
+	"url":       4,
+	"method":    3,
+	"body":      2,
+	"multipart": 2,
+}
+
+type FieldDistance struct {
+	MatchResult
+	Distance float64 `json:"distance"`
+}
+
+type NearMiss struct {
+	Stub     *MockData       `json:"stub"`
+	Distance float64         `json:"distance"`
+	Fields   []FieldDistance `json:"fields"`
+}
+
+// ParsedStub is a stub with its conditions, parsed once with a context whose
+// accessors return the current request.
+type ParsedStub struct {
+	Stub       *MockData
+	Conditions *ParsedConditions
+}
+
+// FindNearMisses ranks the stubs which don't match the request by how close
+// they came, from the nearest one. Only the first limit stubs are returned
+// unless limit isn't positive. Stubs which can't be parsed for the context,
+// e.g. matching the method without DataContext.Method, are skipped.
+//
+// Every stub is parsed again, which compiles its schemas and reads its JWKS
+// files, so servers should rather parse the stubs once and use
+// RankNearMisses.
+func FindNearMisses(stubs []MockData, context *DataContext, limit int) []NearMiss {
+	parsedStubs := []ParsedStub{}
+	for index := range stubs {
+		stub := &stubs[index]
+		if stub.Request == nil {
+			continue
+		}
+		parsedConditions, err := ParseCondition(stub.Request, context)
+		if err != nil {
+			continue
+		}
+		parsedStubs = append(parsedStubs, ParsedStub{stub, parsedConditions})
+	}
+	return RankNearMisses(parsedStubs, limit)
+}
+
+// RankNearMisses ranks the parsed stubs which don't match the current request
+// like FindNearMisses.
+func RankNearMisses(stubs []ParsedStub, limit int) []NearMiss {
+	nearMisses := []NearMiss{}
+	for _, stub := range stubs {
+		if stub.Conditions == nil {
+			continue
+		}
+		if matched, err := stub.Conditions.Condition.Check(); err == nil && matched {
+			continue
+		}
+		nearMisses = append(nearMisses, loadNearMiss(stub.Stub, stub.Conditions.Explain()))
+	}
+	sort.SliceStable(nearMisses, func(i, j int) bool {
+		return nearMisses[i].Distance < nearMisses[j].Distance
+	})
+	if limit > 0 && len(nearMisses) > limit {
+		nearMisses = nearMisses[:limit]
+	}
+	return nearMisses
+}
+
+func loadNearMiss(stub *MockData, results []MatchResult) NearMiss {
+	weightTotal, distanceTotal := 0.0, 0.0
+	fields := []FieldDistance{}
+	for _, result := range results {
+		distance := fieldDistance(result)
+		weight := nearMissWeight(result.Kind)
+		weightTotal += weight
+		distanceTotal += weight * distance
+		fields = append(fields, FieldDistance{result, distance})
+	}
+	nearMiss := NearMiss{Stub: stub, Fields: fields}
+	if weightTotal > 0 {
+		nearMiss.Distance = distanceTotal / weightTotal
+	}
+	return nearMiss
+}
+
+func nearMissWeight(kind string) float64 {
+	if weight, ok := nearMissWeights[kind]; ok {
+		return weight
+	}
+	return 1
+}
+
+func fieldDistance(result MatchResult) float64 {
+	if result.Matched {
+		return 0
+	}
+	// any of the grouped fields is enough, so the nearest one counts
+	if len(result.AnyOf) > 0 {
+		distance := 1.0
+		for _, anyOf := range result.AnyOf {
+			distance = min(distance, fieldDistance(anyOf))
+		}
+		return distance
+	}
+	if len(result.literal) == 0 || len(result.Actual) == 0 {
+		return 1
+	}
+	return normalizedLevenshtein(result.literal, result.Actual)
+}
+
+// normalizedLevenshtein returns the edit distance of the strings scaled to
+// [0, 1]. Long values are cut to keep the comparison cheap for big bodies.
+func normalizedLevenshtein(a string, b string) float64 {
+	runesA, runesB := []rune(a), []rune(b)
+	if len(runesA) > nearMissCompareLimit {
+		runesA = runesA[:nearMissCompareLimit]
+	}
+	if len(runesB) > nearMissCompareLimit {
+		runesB = runesB[:nearMissCompareLimit]
+	}
+	maxLen := max(len(runesA), len(runesB))
+	if maxLen == 0 {
+		return 0
+	}
+	previous := make([]int, len(runesB)+1)
+	current := make([]int, len(runesB)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(runesA); i++ {
+		current[0] = i
+		for j := 1; j <= len(runesB); j++ {
+			cost := 1
+			if runesA[i-1] == runesB[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return float64(previous[len(runesB)]) / float64(maxLen)
+}
@@ -0,0 +1,97 @@
+package wiregock
+
+import (
+	"testing"
+)
+
+func TestFindNearMisses(t *testing.T) {
+	user, admin, other := "user", "admin", "other"
+	stubs := []MockData{
+		{Request: &MockRequest{Headers: map[string]Filter{"X-Role": {EqualTo: &admin}}}},
+		{Request: &MockRequest{Headers: map[string]Filter{"X-Role": {EqualTo: &user}}}},
+		{Request: &MockRequest{Headers: map[string]Filter{"X-Role": {EqualTo: &other}}, QueryParameters: map[string]Filter{"q": {EqualTo: &other}}}},
+		{Request: &MockRequest{Headers: map[string]Filter{"X-Role": {EqualTo: &other}}}},
+	}
+	context := DataContext{
+		Get:    func(key string) string { return "users" },
+		Params: func(key string) string { return "other" },
+	}
+	nearMisses := FindNearMisses(stubs, &context, 3)
+	if len(nearMisses) != 3 {
+		t.Fatalf(`Wrong number of near misses: %d`, len(nearMisses))
+	}
+	if nearMisses[0].Stub != &stubs[1] {
+		t.Fatalf(`Nearest miss isn't the closest stub: %+v`, nearMisses[0])
+	}
+	if nearMisses[1].Stub != &stubs[2] {
+		t.Fatalf(`Partially matched stub isn't ranked second: %+v`, nearMisses[1])
+	}
+	for _, nearMiss := range nearMisses {
+		if nearMiss.Distance <= 0 || nearMiss.Distance > 1 {
+			t.Fatalf(`Wrong near miss distance: %f`, nearMiss.Distance)
+		}
+		for _, field := range nearMiss.Fields {
+			if field.Matched != (field.Distance == 0) {
+				t.Fatalf(`Wrong field distance: %+v`, field)
+			}
+		}
+	}
+}
+
+func TestFindNearMissesMatchingTypeAny(t *testing.T) {
+	user, admin, any := "user", "admin", "ANY"
+	stubs := []MockData{
+		{Request: &MockRequest{MatchingType: &any, Headers: map[string]Filter{"X-Role": {EqualTo: &admin}}, QueryParameters: map[string]Filter{"q": {EqualTo: &user}}}},
+		{Request: &MockRequest{MatchingType: &any, Headers: map[string]Filter{"X-Role": {EqualTo: &admin}}, QueryParameters: map[string]Filter{"q": {EqualTo: &admin}}}},
+	}
+	context := DataContext{
+		Get:    func(key string) string { return "users" },
+		Params: func(key string) string { return "user" },
+	}
+	nearMisses := FindNearMisses(stubs, &context, 0)
+	if len(nearMisses) != 1 || nearMisses[0].Stub != &stubs[1] {
+		t.Fatalf(`Wrong near misses: %+v`, nearMisses)
+	}
+	fields := nearMisses[0].Fields
+	if len(fields) != 1 || fields[0].Kind != "anyOf" || fields[0].Matched || len(fields[0].AnyOf) != 2 {
+		t.Fatalf(`Parameters of matchingType ANY aren't grouped: %+v`, fields)
+	}
+	if fields[0].Distance != fieldDistance(fields[0].AnyOf[0]) && fields[0].Distance != fieldDistance(fields[0].AnyOf[1]) {
+		t.Fatalf(`Wrong distance of the group: %f`, fields[0].Distance)
+	}
+}
+
+func TestFindNearMissesUnparsed(t *testing.T) {
+	user, post := "user", "POST"
+	stubs := []MockData{
+		{Request: &MockRequest{Method: &post, Headers: map[string]Filter{"X-Role": {EqualTo: &user}}}},
+		{Request: &MockRequest{Headers: map[string]Filter{"X-Role": {EqualTo: &user}}}},
+	}
+	context := DataContext{Get: func(key string) string { return "users" }}
+	nearMisses := FindNearMisses(stubs, &context, 0)
+	if len(nearMisses) != 1 || nearMisses[0].Stub != &stubs[1] {
+		t.Fatalf(`Stub without DataContext.Method isn't skipped: %+v`, nearMisses)
+	}
+	parsedConditions, err := ParseCondition(stubs[1].Request, &context)
+	if err != nil {
+		t.Fatalf(`Error parsing stub: %s`, err)
+	}
+	ranked := RankNearMisses([]ParsedStub{{&stubs[0], nil}, {&stubs[1], parsedConditions}}, 0)
+	if len(ranked) != 1 || ranked[0].Stub != &stubs[1] || ranked[0].Distance != nearMisses[0].Distance {
+		t.Fatalf(`Wrong near misses of parsed stubs: %+v`, ranked)
+	}
+}
+
+func TestNormalizedLevenshtein(t *testing.T) {
+	distances := map[[2]string]float64{
+		{"", ""}:              0,
+		{"abc", "abc"}:        0,
+		{"abc", ""}:           1,
+		{"kitten", "sitting"}: 3.0 / 7.0,
+	}
+	for pair, expected := range distances {
+		if distance := normalizedLevenshtein(pair[0], pair[1]); distance != expected {
+			t.Fatalf(`Wrong distance between %s and %s: %f`, pair[0], pair[1], distance)
+		}
+	}
+}
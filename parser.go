@@ -1,29 +1,115 @@
 package wiregock
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
-
-	"github.com/antchfx/jsonquery"
-	"github.com/antchfx/xmlquery"
 )
 
+// FileFormData is a part of a multipart body. Data holds the bytes of the
+// part as they are.
 type FileFormData struct {
+	Name     string
 	FileName string
 	Headers  map[string][]string
 	Data     string
 }
 
+// DataContext describes the request being matched. The body is read on every
+// check, but parsed only once per distinct body and then shared by all the
+// conditions parsed with the context. Method, Path and RequestURI are
+// required by the stubs matching the method or the URL.
 type DataContext struct {
+	Method        func() string
+	Path          func() string
+	RequestURI    func() string
 	Body          func() string
+	BodyBytes     func() []byte
 	Get           func(key string) string
 	GetMulti      func(key string) []string
 	Params        func(key string) string
 	ParamsMulti   func(key string) []string
 	Cookies       func(key string) string
 	FormValue     func(key string) string
+	FormValues    func(key string) []string
 	MultipartForm func() []FileFormData
+	bodyMutex     sync.Mutex
+	body          *requestBody
+}
+
+// requestBody keeps the parsed body while the request behind the context
+// returns the same body.
+func (context *DataContext) requestBody() *requestBody {
+	context.bodyMutex.Lock()
+	defer context.bodyMutex.Unlock()
+	if context.BodyBytes != nil {
+		data := context.BodyBytes()
+		if context.body == nil || context.body.raw != string(data) {
+			context.body = newRequestBodyBytes(data)
+		}
+		return context.body
+	}
+	raw := ""
+	if context.Body != nil {
+		raw = context.Body()
+	}
+	if context.body == nil || context.body.raw != raw {
+		context.body = newRequestBody(raw)
+	}
+	return context.body
+}
+
+// multipartForm takes the parts from MultipartForm if the caller provides
+// it, or else parses the body by the boundary of its Content-Type. A
+// malformed body has no parts.
+func (context *DataContext) multipartForm() []FileFormData {
+	if context.MultipartForm != nil {
+		return context.MultipartForm()
+	}
+	if context.Get == nil {
+		return nil
+	}
+	return context.requestBody().multipart(context.Get("Content-Type"))
+}
+
+// formValues takes form parameters from FormValues or FormValue if the
+// caller provides them, or else parses an application/x-www-form-urlencoded
+// body.
+func (context *DataContext) formValues(key string) []string {
+	if context.FormValues != nil {
+		return context.FormValues(key)
+	}
+	if context.FormValue != nil {
+		if value := context.FormValue(key); value != "" {
+			return []string{value}
+		}
+		return nil
+	}
+	if context.Get != nil {
+		contentType := context.Get("Content-Type")
+		if contentType != "" && !strings.Contains(strings.ToLower(contentType), "application/x-www-form-urlencoded") {
+			return nil
+		}
+	}
+	return context.requestBody().form()[key]
+}
+
+func (context *DataContext) formValue(key string) string {
+	values := context.formValues(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
 }
 
 type ParsedConditions struct {
@@ -31,160 +117,364 @@ type ParsedConditions struct {
 	Condition   Condition
 }
 
+func (parsedConditions ParsedConditions) Explain() []MatchResult {
+	return ExplainCondition(parsedConditions.Condition)
+}
+
 func isMulti(filter *Filter) bool {
 	return len(filter.Includes) > 0 || len(filter.HasExactly) > 0
 }
 
 func ParseCondition(request *MockRequest, context *DataContext) (*ParsedConditions, error) {
-	conditions := []Condition{}
+	matchAny, err := parseMatchingType(request.MatchingType)
+	if err != nil {
+		return nil, err
+	}
+
+	conditions, err := createUrlConditions(request, context)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Method != nil {
+		newCondition, err := createMethodCondition(*request.Method, context)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, *newCondition)
+	}
+
+	parameterConditions := []Condition{}
 	if request.Headers != nil {
 		for key, value := range request.Headers {
-			newCondition, err := createCondition(&value, func() string { return context.Get(key) })
+			newCondition, err := createParameterCondition(&value, "header", key, func() string { return context.Get(key) }, func() []string { return context.GetMulti(key) })
 			if err != nil {
 				return nil, err
 			}
-			conditions = append(conditions, *newCondition)
-			if isMulti(&value) {
-				newConditionMulti, err := createConditionMulti(&value, func() []string { return context.GetMulti(key) })
-				if err != nil {
-					return nil, err
-				}
-				conditions = append(conditions, *newConditionMulti)
-			}
+			parameterConditions = append(parameterConditions, newCondition)
 		}
 	}
 
 	if request.QueryParameters != nil {
 		for key, value := range request.QueryParameters {
-			newCondition, err := createCondition(&value, func() string { return context.Params(key) })
+			newCondition, err := createParameterCondition(&value, "query", key, func() string { return context.Params(key) }, func() []string { return context.ParamsMulti(key) })
 			if err != nil {
 				return nil, err
 			}
-			conditions = append(conditions, *newCondition)
-			if isMulti(&value) {
-				newConditionMulti, err := createConditionMulti(&value, func() []string { return context.ParamsMulti(key) })
-				if err != nil {
-					return nil, err
-				}
-				conditions = append(conditions, *newConditionMulti)
-			}
+			parameterConditions = append(parameterConditions, newCondition)
 		}
 	}
 
 	if request.Cookies != nil {
 		for key, value := range request.Cookies {
-			newCondition, err := createCondition(&value, func() string { return context.Cookies(key) })
+			newCondition, err := createCondition(&value, "cookie", key, func() string { return context.Cookies(key) })
 			if err != nil {
 				return nil, err
 			}
-			conditions = append(conditions, *newCondition)
+			parameterConditions = append(parameterConditions, *newCondition)
 		}
 	}
 
 	if request.FormParameters != nil {
 		for key, value := range request.FormParameters {
-			newCondition, err := createCondition(&value, func() string { return context.FormValue(key) })
+			newCondition, err := createParameterCondition(&value, "form", key, func() string { return context.formValue(key) }, func() []string { return context.formValues(key) })
 			if err != nil {
 				return nil, err
 			}
-			conditions = append(conditions, *newCondition)
+			parameterConditions = append(parameterConditions, newCondition)
+		}
+	}
+
+	if request.BasicAuthCredentials != nil {
+		newCondition, err := createBasicAuthCondition(request.BasicAuthCredentials, func() string { return context.Get("Authorization") })
+		if err != nil {
+			return nil, err
 		}
+		parameterConditions = append(parameterConditions, *newCondition)
 	}
 
 	isMultipart := len(request.MultipartPatterns) > 0
 
 	if isMultipart {
-		for _, value := range request.MultipartPatterns {
-			newCondition, err := createMultipartFileCondition(&value, func() []FileFormData { return context.MultipartForm() })
+		for index, value := range request.MultipartPatterns {
+			newCondition, err := createMultipartFileCondition(&value, strconv.Itoa(index), context.multipartForm)
 			if err != nil {
 				return nil, err
 			}
-			conditions = append(conditions, *newCondition)
+			parameterConditions = append(parameterConditions, *newCondition)
 		}
 	}
 
 	if len(request.BodyPatterns) > 0 {
-		for _, value := range request.BodyPatterns {
-			newCondition, err := createCondition(&value, func() string { return context.Body() })
+		for index, value := range request.BodyPatterns {
+			newCondition, err := createBodyCondition(&value, strconv.Itoa(index), context.requestBody)
 			if err != nil {
 				return nil, err
 			}
-			conditions = append(conditions, *newCondition)
+			parameterConditions = append(parameterConditions, *newCondition)
 		}
 	}
 
+	// URL and method always have to match, matchingType only decides
+	// whether all or any of the other parameters should.
+	if matchAny && len(parameterConditions) > 0 {
+		conditions = append(conditions, OrCondition{parameterConditions})
+	} else {
+		conditions = append(conditions, parameterConditions...)
+	}
+
 	return &ParsedConditions{
 		IsMultipart: isMultipart,
 		Condition:   AndCondition{conditions},
 	}, nil
 }
 
+func parseMatchingType(matchingType *string) (bool, error) {
+	if matchingType == nil {
+		return false, nil
+	}
+	switch strings.ToUpper(*matchingType) {
+	case "ALL":
+		return false, nil
+	case "ANY":
+		return true, nil
+	}
+	return false, fmt.Errorf("unsupported matchingType: %s", *matchingType)
+}
+
+func createParameterCondition(filter *Filter, kind string, name string, loaderMethod func() string, loaderMethodMulti func() []string) (Condition, error) {
+	newCondition, err := createCondition(filter, kind, name, loaderMethod)
+	if err != nil {
+		return nil, err
+	}
+	if !isMulti(filter) {
+		return *newCondition, nil
+	}
+	newConditionMulti, err := createConditionMulti(filter, kind, name, loaderMethodMulti)
+	if err != nil {
+		return nil, err
+	}
+	// A filter with nothing but includes or hasExactly has no rules for the
+	// single value, and an empty BlockRule never matches.
+	if blockRule, ok := newCondition.blockRule.(*BlockRule); ok && len(blockRule.rulesAnd) == 0 && len(blockRule.rulesOr) == 0 {
+		return *newConditionMulti, nil
+	}
+	return AndCondition{[]Condition{*newCondition, *newConditionMulti}}, nil
+}
+
+func createMethodCondition(methodNames string, context *DataContext) (*DataCondition, error) {
+	methodRule, err := parseMethodRule(methodNames)
+	if err != nil {
+		return nil, err
+	}
+	if context.Method == nil && (len(methodRule.methods) > 0 || len(methodRule.excluded) > 0) {
+		return nil, errors.New("method matching requires DataContext.Method")
+	}
+	return &DataCondition{
+		conditionInfo: conditionInfo{"method", "", methodNames, methodNames},
+		loaderMethod:  context.Method,
+		blockRule:     *methodRule,
+	}, nil
+}
+
+func createUrlConditions(request *MockRequest, context *DataContext) ([]Condition, error) {
+	conditions := []Condition{}
+	urlFilters := []struct {
+		name      string
+		value     *string
+		isPattern bool
+		onlyPath  bool
+	}{
+		{"url", request.Url, false, false},
+		{"urlPath", request.UrlPath, false, true},
+		{"urlPattern", request.UrlPattern, true, false},
+		{"urlPathPattern", request.UrlPathPattern, true, true},
+	}
+	for _, urlFilter := range urlFilters {
+		if urlFilter.value == nil {
+			continue
+		}
+		val := *urlFilter.value
+		filter := Filter{EqualTo: &val}
+		if urlFilter.isPattern {
+			regexStr := fmt.Sprintf("^(?:%s)$", val)
+			filter = Filter{Matches: &regexStr}
+		}
+		loaderMethod, loaderName := context.RequestURI, "RequestURI"
+		if urlFilter.onlyPath {
+			loaderMethod, loaderName = context.Path, "Path"
+		}
+		if loaderMethod == nil {
+			return nil, fmt.Errorf("%s matching requires DataContext.%s", urlFilter.name, loaderName)
+		}
+		newCondition, err := createCondition(&filter, "url", urlFilter.name, loaderMethod)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, *newCondition)
+	}
+	pathTemplateConditions, err := createPathTemplateConditions(request, context)
+	if err != nil {
+		return nil, err
+	}
+	return append(conditions, pathTemplateConditions...), nil
+}
+
+func createPathTemplateConditions(request *MockRequest, context *DataContext) ([]Condition, error) {
+	if request.UrlPathTemplate == nil {
+		if len(request.PathParameters) > 0 {
+			return nil, errors.New("pathParameters require urlPathTemplate")
+		}
+		return nil, nil
+	}
+	pathTemplate, err := ParsePathTemplate(*request.UrlPathTemplate)
+	if err != nil {
+		return nil, err
+	}
+	conditions := []Condition{DataCondition{
+		conditionInfo: conditionInfo{"url", "urlPathTemplate", pathTemplate.template, pathTemplate.template},
+		loaderMethod:  context.Path,
+		blockRule:     PathTemplateRule{pathTemplate},
+	}}
+	for key, value := range request.PathParameters {
+		if !slices.Contains(pathTemplate.Parameters(), key) {
+			return nil, fmt.Errorf("path parameter %s is missing in urlPathTemplate %s", key, pathTemplate.template)
+		}
+		newCondition, err := createCondition(&value, "pathParameter", key, func() string {
+			values, _ := pathTemplate.Match(context.Path())
+			return values[key]
+		})
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, *newCondition)
+	}
+	if context.Path == nil {
+		return nil, errors.New("urlPathTemplate matching requires DataContext.Path")
+	}
+	return conditions, nil
+}
+
 type ParsedRules struct {
-	rulesAnd []Rule
-	rulesOr  []Rule
+	includes   []Rule
+	hasExactly []Rule
+}
+
+func createCondition(filter *Filter, kind string, name string, loaderMethod func() string) (*DataCondition, error) {
+	parsedRules, err := parseRules(filter, true)
+	if err != nil {
+		return nil, err
+	}
+	return &DataCondition{conditionInfo{kind, name, describe(filter), filter.literal()}, loaderMethod, parsedRules}, err
 }
 
-func createCondition(filter *Filter, loaderMethod func() string) (*DataCondition, error) {
+func createBodyCondition(filter *Filter, name string, loaderMethod func() *requestBody) (*BodyCondition, error) {
 	parsedRules, err := parseRules(filter, true)
 	if err != nil {
 		return nil, err
 	}
-	return &DataCondition{loaderMethod, parsedRules}, err
+	return &BodyCondition{conditionInfo{"body", name, describe(filter), filter.literal()}, loaderMethod, parsedRules}, nil
 }
 
-func createConditionMulti(filter *Filter, loaderMethod func() []string) (*MultiDataCondition, error) {
+func createConditionMulti(filter *Filter, kind string, name string, loaderMethod func() []string) (*MultiDataCondition, error) {
 	parsedRules, err := parseRulesMulti(filter)
 	if err != nil {
 		return nil, err
 	}
-	return &MultiDataCondition{loaderMethod, parsedRules.rulesAnd, parsedRules.rulesOr}, err
+	return &MultiDataCondition{conditionInfo{kind, name, describe(filter), ""}, loaderMethod, parsedRules.includes, parsedRules.hasExactly}, err
 }
 
-func createMultipartFileCondition(multipartPatternsData *MultipartPatternsData, loaderMethod func() []FileFormData) (*FileDataCondition, error) {
-	checkAny := false
+func createBasicAuthCondition(basicAuthCredentials *BasicAuthCredentials, loaderMethod func() string) (*BasicAuthCondition, error) {
+	condition := BasicAuthCondition{loaderMethod: loaderMethod}
+	if basicAuthCredentials.Username != nil {
+		usernameRule, err := parseRules(basicAuthCredentials.Username, true)
+		if err != nil {
+			return nil, err
+		}
+		condition.usernameRule = usernameRule
+		condition.literal = basicAuthCredentials.Username.literal()
+	}
+	if basicAuthCredentials.Password != nil {
+		passwordRule, err := parseRules(basicAuthCredentials.Password, true)
+		if err != nil {
+			return nil, err
+		}
+		condition.passwordRule = passwordRule
+	}
+	condition.kind = "basicAuth"
+	condition.expected = describe(basicAuthCredentials)
+	return &condition, nil
+}
+
+// decodeBinary decodes the base64 of binary matchers, padded or not, in the
+// standard or the URL alphabet.
+func decodeBinary(str string) ([]byte, error) {
+	str = strings.TrimRight(strings.TrimSpace(str), "=")
+	if strings.ContainsAny(str, "-_") {
+		return base64.RawURLEncoding.DecodeString(str)
+	}
+	return base64.RawStdEncoding.DecodeString(str)
+}
+
+func decodeSha256(digest string) ([]byte, error) {
+	val, err := hex.DecodeString(strings.TrimSpace(digest))
+	if err != nil {
+		return nil, err
+	}
+	if len(val) != sha256.Size {
+		return nil, fmt.Errorf("SHA-256 digest must be %d bytes, got %d", sha256.Size, len(val))
+	}
+	return val, nil
+}
+
+func createMultipartFileCondition(multipartPatternsData *MultipartPatternsData, name string, loaderMethod func() []FileFormData) (*FileDataCondition, error) {
+	// Unlike the stub itself, a multipart pattern matches ANY part by default.
+	matchAll := false
 	if multipartPatternsData.MatchingType != nil {
-		checkAny = strings.Compare(*multipartPatternsData.MatchingType, "ALL") == 0
+		matchAny, err := parseMatchingType(multipartPatternsData.MatchingType)
+		if err != nil {
+			return nil, err
+		}
+		matchAll = !matchAny
 	}
-	var ruleBody BlockRule
-	if multipartPatternsData.BodyPatterns != nil {
+	var rulesBody Rule
+	if len(multipartPatternsData.BodyPatterns) > 0 {
 		bodyPatternRules := []Rule{}
 		for _, bodyPatterns := range multipartPatternsData.BodyPatterns {
-			ruleBodyItem, err := parseRules(&bodyPatterns, checkAny)
+			ruleBodyItem, err := parseRules(&bodyPatterns, true)
 			if err != nil {
 				return nil, err
 			}
 			bodyPatternRules = append(bodyPatternRules, ruleBodyItem)
 		}
-		if checkAny {
-			ruleBody = BlockRule{rulesAnd: []Rule{}, rulesOr: bodyPatternRules}
-		} else {
-			ruleBody = BlockRule{rulesAnd: bodyPatternRules, rulesOr: []Rule{}}
-		}
+		rulesBody = BlockRule{rulesAnd: bodyPatternRules}
 	}
 	var rulesFileName Rule
 	if multipartPatternsData.FileName != nil {
-		rulesFileNameInfo, err := parseRules(multipartPatternsData.FileName, checkAny)
+		rulesFileNameInfo, err := parseRules(multipartPatternsData.FileName, true)
 		if err != nil {
 			return nil, err
 		}
 		rulesFileName = rulesFileNameInfo
 	}
 	rulesHeader := map[string]Rule{}
-	if multipartPatternsData.Headers != nil {
-		for key, header := range multipartPatternsData.Headers {
-			val, err := parseRules(&header, checkAny)
-			if err != nil {
-				return nil, err
-			}
-			rulesHeader[key] = val
+	absentHeaders := map[string]bool{}
+	for key, header := range multipartPatternsData.Headers {
+		val, err := parseRules(&header, true)
+		if err != nil {
+			return nil, err
 		}
+		rulesHeader[key] = val
+		absentHeaders[key] = header.Absent != nil && *header.Absent
 	}
 	return &FileDataCondition{
-		checkAny:      checkAny,
+		conditionInfo: conditionInfo{kind: "multipart", name: name, expected: describe(multipartPatternsData)},
+		matchAll:      matchAll,
 		loaderMethod:  loaderMethod,
 		rulesHeader:   rulesHeader,
+		absentHeaders: absentHeaders,
 		rulesFileName: rulesFileName,
-		rulesBody:     ruleBody,
+		rulesBody:     rulesBody,
 	}, nil
 }
 
@@ -194,86 +484,40 @@ type XPathFilterProps struct {
 	ignoreExtraElements bool
 }
 
-func loadFilterProps(filter *Filter) XPathFilterProps {
-	caseInsensitive := false
-	if filter.CaseInsensitive != nil {
-		caseInsensitive = *filter.CaseInsensitive
-	}
-	ignoreArrayOrder := true
-	if filter.IgnoreArrayOrder != nil {
-		ignoreArrayOrder = *filter.IgnoreArrayOrder
-	}
-	ignoreExtraElements := true
-	if filter.IgnoreExtraElements != nil {
-		ignoreExtraElements = *filter.IgnoreExtraElements
-	}
-	return XPathFilterProps{
-		caseInsensitive,
-		ignoreArrayOrder,
-		ignoreExtraElements,
-	}
-}
-
-func loadXPathFilterProps(filter *XPathFilter, xPathFilterPropsDefault *XPathFilterProps) XPathFilterProps {
-	caseInsensitive := false
+func loadFilterProps(filter *Filter, xPathFilterPropsDefault *XPathFilterProps) XPathFilterProps {
+	xPathFilterProps := XPathFilterProps{}
 	if xPathFilterPropsDefault != nil {
-		caseInsensitive = xPathFilterPropsDefault.caseInsensitive
+		xPathFilterProps = *xPathFilterPropsDefault
 	}
 	if filter.CaseInsensitive != nil {
-		caseInsensitive = *filter.CaseInsensitive
-	}
-	ignoreArrayOrder := true
-	if xPathFilterPropsDefault != nil {
-		ignoreArrayOrder = xPathFilterPropsDefault.ignoreArrayOrder
+		xPathFilterProps.caseInsensitive = *filter.CaseInsensitive
 	}
 	if filter.IgnoreArrayOrder != nil {
-		ignoreArrayOrder = *filter.IgnoreArrayOrder
-	}
-	ignoreExtraElements := true
-	if xPathFilterPropsDefault != nil {
-		ignoreExtraElements = xPathFilterPropsDefault.ignoreExtraElements
+		xPathFilterProps.ignoreArrayOrder = *filter.IgnoreArrayOrder
 	}
 	if filter.IgnoreExtraElements != nil {
-		ignoreExtraElements = *filter.IgnoreExtraElements
-	}
-	return XPathFilterProps{
-		caseInsensitive,
-		ignoreArrayOrder,
-		ignoreExtraElements,
-	}
-}
-
-func loadXPathFilterRules(filterPath *XPathFilter, caseInsensitive bool) []Rule {
-	xPathRules := []Rule{}
-	if filterPath.EqualTo != nil {
-		xPathRules = append(xPathRules, EqualToRule{*filterPath.EqualTo, caseInsensitive})
-	}
-	if filterPath.Contains != nil {
-		xPathRules = append(xPathRules, ContainsRule{*filterPath.Contains, caseInsensitive})
-	}
-	actualFormat := time.RFC3339
-	if filterPath.Before != nil || filterPath.After != nil || filterPath.EqualToDateTime != nil {
-		if filterPath.ActualFormat != nil {
-			actualFormat = *filterPath.ActualFormat
-		}
-		xPathRules = append(xPathRules, DateTimeRule{filterPath.Before, filterPath.After, filterPath.EqualToDateTime, actualFormat})
+		xPathFilterProps.ignoreExtraElements = *filter.IgnoreExtraElements
 	}
-	return xPathRules
+	return xPathFilterProps
 }
 
 type XPathFactory interface {
 	generateEqualsRule(query string, xPathFilterProps *XPathFilterProps) (Rule, error)
-	generateMatchesXPathRule(filterPath *XPathFilter, xPathFilterPropsDefault *XPathFilterProps) (Rule, *XPathFilterProps, error)
+	generateMatchesXPathRule(filterPath *XPathFilter, xPathFilterPropsDefault *XPathFilterProps) (Rule, error)
 }
 
 type XPathJsonFactory struct{}
 
 func (xPathFactory XPathJsonFactory) generateEqualsRule(query string, xPathFilterProps *XPathFilterProps) (Rule, error) {
-	node, err := jsonquery.Parse(strings.NewReader(query))
+	value, err := parseJson(query)
 	if err != nil {
 		return nil, err
 	}
-	rule := EqualToJsonRule{node: node, EqualToBaseRule: parseEqualToBaseRule(xPathFilterProps)}
+	value, err = loadJsonPlaceholders(value)
+	if err != nil {
+		return nil, err
+	}
+	rule := EqualToJsonRule{value: value, EqualToBaseRule: parseEqualToBaseRule(xPathFilterProps)}
 	return rule, err
 }
 
@@ -285,44 +529,50 @@ func parseEqualToBaseRule(xPathFilterProps *XPathFilterProps) EqualToBaseRule {
 }
 
 func (xPathFactory XPathJsonFactory) generateMatchesXPathRule(filterPath *XPathFilter, xPathFilterPropsDefault *XPathFilterProps) (Rule, error) {
-	xPathFilterPropsLocal := loadXPathFilterProps(filterPath, xPathFilterPropsDefault)
-	xPathRules := loadXPathFilterRules(filterPath, xPathFilterPropsLocal.caseInsensitive)
-	if filterPath.EqualToJson != nil {
-		ruleJson, err := xPathFactory.generateEqualsRule(*filterPath.EqualToJson, &xPathFilterPropsLocal)
-		if err != nil {
-			return nil, err
-		}
-		xPathRules = append(xPathRules, ruleJson)
+	innerRule, err := parseXPathInnerRule(filterPath, xPathFilterPropsDefault)
+	if err != nil {
+		return nil, err
 	}
-	if filterPath.EqualToXml != nil {
-		ruleXml, err := xPathFactory.generateEqualsRule(*filterPath.EqualToXml, &xPathFilterPropsLocal)
-		if err != nil {
-			return nil, err
-		}
-		xPathRules = append(xPathRules, ruleXml)
+	rule, err := newMatchesJsonPathRule(filterPath.Expression, innerRule)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON path %s: %w", filterPath.Expression, err)
 	}
-	for _, filterPathSub := range filterPath.And {
-		ruleSub, err := xPathFactory.generateMatchesXPathRule(&filterPathSub, &xPathFilterPropsLocal)
-		if err != nil {
-			return nil, err
-		}
-		xPathRules = append(xPathRules, ruleSub)
+	return rule, nil
+}
+
+// parseXPathInnerRule parses the matchers applied to the values selected by
+// a JSON path or an XPath. It returns nil when there are none, so only the
+// presence of the values is checked.
+func parseXPathInnerRule(filterPath *XPathFilter, xPathFilterPropsDefault *XPathFilterProps) (Rule, error) {
+	innerRule, err := parseRulesWithProps(&filterPath.Filter, true, xPathFilterPropsDefault)
+	if err != nil {
+		return nil, err
 	}
-	rule := MatchesJsonPathRule{
-		path:      filterPath.Expression,
-		innerRule: BlockRule{rulesOr: xPathRules},
+	if len(innerRule.rulesAnd) == 0 && len(innerRule.rulesOr) == 0 {
+		return nil, nil
 	}
-	return rule, nil
+	return *innerRule, nil
 }
 
 type XPathXmlFactory struct{}
 
 func (xPathFactory XPathXmlFactory) generateEqualsRule(query string, xPathFilterProps *XPathFilterProps) (Rule, error) {
-	node, err := xmlquery.Parse(strings.NewReader(query))
+	return generateEqualToXmlRule(query, xPathFilterProps, XmlComparisonProps{})
+}
+
+func generateEqualToXmlRule(query string, xPathFilterProps *XPathFilterProps, xmlComparisonProps XmlComparisonProps) (Rule, error) {
+	element, err := parseXml(query)
 	if err != nil {
 		return nil, err
 	}
-	rule := EqualToXmlRule{node: node, EqualToBaseRule: parseEqualToBaseRule(xPathFilterProps)}
+	if err := xmlComparisonProps.compilePlaceholders(element); err != nil {
+		return nil, err
+	}
+	rule := EqualToXmlRule{
+		element:            element,
+		EqualToBaseRule:    parseEqualToBaseRule(xPathFilterProps),
+		XmlComparisonProps: xmlComparisonProps,
+	}
 	return rule, err
 }
 
@@ -331,38 +581,24 @@ func (xPathFactory XPathXmlFactory) generateMatchesXPathRule(filterPath *XPathFi
 	if err != nil {
 		return nil, err
 	}
-	xPathFilterPropsLocal := loadXPathFilterProps(filterPath, xPathFilterPropsDefault)
-	xPathRules := loadXPathFilterRules(filterPath, xPathFilterPropsLocal.caseInsensitive)
-	if filterPath.EqualToJson != nil {
-		ruleJson, err := xPathFactory.generateEqualsRule(*filterPath.EqualToJson, &xPathFilterPropsLocal)
-		if err != nil {
-			return nil, err
-		}
-		xPathRules = append(xPathRules, ruleJson)
-	}
-	if filterPath.EqualToXml != nil {
-		ruleXml, err := xPathFactory.generateEqualsRule(*filterPath.EqualToXml, &xPathFilterPropsLocal)
-		if err != nil {
-			return nil, err
-		}
-		xPathRules = append(xPathRules, ruleXml)
-	}
-	for _, filterPathSub := range filterPath.And {
-		ruleSub, err := xPathFactory.generateMatchesXPathRule(&filterPathSub, &xPathFilterPropsLocal)
-		if err != nil {
-			return nil, err
-		}
-		xPathRules = append(xPathRules, ruleSub)
+	innerRule, err := parseXPathInnerRule(filterPath, xPathFilterPropsDefault)
+	if err != nil {
+		return nil, err
 	}
 	rule := MatchesXmlXPathRule{
 		xPath:     xPath,
-		innerRule: BlockRule{rulesOr: xPathRules},
+		innerRule: innerRule,
 	}
 	return rule, nil
 }
 
 func parseRules(filter *Filter, defaultAnd bool) (*BlockRule, error) {
-	rules, err := parseRule(filter)
+	return parseRulesWithProps(filter, defaultAnd, nil)
+}
+
+func parseRulesWithProps(filter *Filter, defaultAnd bool, xPathFilterPropsDefault *XPathFilterProps) (*BlockRule, error) {
+	xPathFilterProps := loadFilterProps(filter, xPathFilterPropsDefault)
+	rules, err := parseRuleWithProps(filter, &xPathFilterProps)
 	if err != nil {
 		return nil, err
 	}
@@ -375,7 +611,7 @@ func parseRules(filter *Filter, defaultAnd bool) (*BlockRule, error) {
 	}
 	if len(filter.And) > 0 {
 		for _, filterAnd := range filter.And {
-			parsedRules, err := parseRule(&filterAnd)
+			parsedRules, err := parseRuleWithProps(&filterAnd, &xPathFilterProps)
 			if err != nil {
 				return nil, err
 			}
@@ -384,7 +620,7 @@ func parseRules(filter *Filter, defaultAnd bool) (*BlockRule, error) {
 	}
 	if len(filter.Or) > 0 {
 		for _, filterOr := range filter.Or {
-			parsedRules, err := parseRule(&filterOr)
+			parsedRules, err := parseRuleWithProps(&filterOr, &xPathFilterProps)
 			if err != nil {
 				return nil, err
 			}
@@ -395,12 +631,19 @@ func parseRules(filter *Filter, defaultAnd bool) (*BlockRule, error) {
 }
 
 func parseRule(filter *Filter) ([]Rule, error) {
+	return parseRuleWithProps(filter, nil)
+}
+
+// parseRuleWithProps is the single place where matchers of a Filter become
+// rules, whether the Filter is top level, a multi-value item or applied to the
+// values selected by a JSON path or an XPath.
+func parseRuleWithProps(filter *Filter, xPathFilterPropsDefault *XPathFilterProps) ([]Rule, error) {
 	xPathJsonFactory := XPathJsonFactory{}
 	xPathXmlFactory := XPathXmlFactory{}
 
 	rules := []Rule{}
 
-	xPathFilterProps := loadFilterProps(filter)
+	xPathFilterProps := loadFilterProps(filter, xPathFilterPropsDefault)
 	caseInsensitive := xPathFilterProps.caseInsensitive
 
 	if filter.Contains != nil {
@@ -414,8 +657,35 @@ func parseRule(filter *Filter) ([]Rule, error) {
 	}
 
 	if filter.BinaryEqualTo != nil {
-		val := *filter.BinaryEqualTo
-		rules = append(rules, EqualToBinaryRule{[]byte(val)})
+		val, err := decodeBinary(*filter.BinaryEqualTo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid binaryEqualTo: %w", err)
+		}
+		rules = append(rules, EqualToBinaryRule{val})
+	}
+
+	if filter.StartsWithBytes != nil {
+		val, err := decodeBinary(*filter.StartsWithBytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid startsWithBytes: %w", err)
+		}
+		rules = append(rules, StartsWithBytesRule{val})
+	}
+
+	if filter.ContainsBytes != nil {
+		val, err := decodeBinary(*filter.ContainsBytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid containsBytes: %w", err)
+		}
+		rules = append(rules, ContainsBytesRule{val})
+	}
+
+	if filter.Sha256EqualTo != nil {
+		val, err := decodeSha256(*filter.Sha256EqualTo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sha256EqualTo: %w", err)
+		}
+		rules = append(rules, Sha256EqualToRule{val})
 	}
 
 	if filter.DoesNotContain != nil {
@@ -453,7 +723,19 @@ func parseRule(filter *Filter) ([]Rule, error) {
 		actualFormat = *filter.ActualFormat
 	}
 	if filter.Before != nil || filter.After != nil || filter.EqualToDateTime != nil {
-		rules = append(rules, DateTimeRule{filter.Before, filter.After, filter.EqualToDateTime, actualFormat})
+		rule, err := parseDateTimeRule(filter, actualFormat)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, *rule)
+	}
+
+	if filter.EqualToNumber != nil || filter.GreaterThan != nil || filter.LessThan != nil || filter.Between != nil {
+		rule, err := parseNumberRule(filter)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, *rule)
 	}
 
 	if filter.EqualToJson != nil {
@@ -464,7 +746,11 @@ func parseRule(filter *Filter) ([]Rule, error) {
 		rules = append(rules, rule)
 	}
 	if filter.EqualToXml != nil {
-		rule, err := xPathXmlFactory.generateEqualsRule(*filter.EqualToXml, &xPathFilterProps)
+		xmlComparisonProps, err := loadXmlComparisonProps(filter.EnablePlaceholders, filter.ExemptedComparisons)
+		if err != nil {
+			return nil, err
+		}
+		rule, err := generateEqualToXmlRule(*filter.EqualToXml, &xPathFilterProps, xmlComparisonProps)
 		if err != nil {
 			return nil, err
 		}
@@ -488,56 +774,238 @@ func parseRule(filter *Filter) ([]Rule, error) {
 	}
 
 	if filter.MatchesJsonSchema != nil {
-		val := *filter.MatchesJsonSchema
-		rules = append(rules, MatchesJsonSchemaRule{val})
+		schema, err := compileJsonSchema(*filter.MatchesJsonSchema, filter.SchemaVersion)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON schema: %w", err)
+		}
+		rules = append(rules, MatchesJsonSchemaRule{schema})
+	}
+
+	if filter.EqualToGraphQL != nil {
+		rule, err := parseGraphQLRule(&GraphQLFilter{Query: filter.EqualToGraphQL}, &xPathFilterProps)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, *rule)
+	}
+
+	if filter.MatchesGraphQL != nil {
+		rule, err := parseGraphQLRule(filter.MatchesGraphQL, &xPathFilterProps)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, *rule)
+	}
+
+	if filter.MatchesJwt != nil {
+		rule, err := parseJwtRule(filter.MatchesJwt, &xPathFilterProps)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, *rule)
+	}
+
+	if filter.CustomMatcher != nil {
+		rule, err := parseCustomMatcher(filter.CustomMatcher)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
 	}
 
 	return rules, nil
 }
 
-func parseRulesMulti(filter *Filter) (*ParsedRules, error) {
-	rulesAnd := []Rule{}
-	rulesOr := []Rule{}
-	if len(filter.Includes) > 0 {
-		for _, filterAnd := range filter.HasExactly {
-			parsedRules, err := parseRuleMulti(&filterAnd)
-			if err != nil {
-				return nil, err
-			}
-			rulesAnd = append(rulesAnd, parsedRules...)
+func parseGraphQLRule(graphQLFilter *GraphQLFilter, xPathFilterProps *XPathFilterProps) (*GraphQLRule, error) {
+	rule := GraphQLRule{}
+	if graphQLFilter.Query != nil {
+		query, err := normalizeGraphQL(*graphQLFilter.Query)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GraphQL query: %w", err)
+		}
+		rule.query = &query
+	}
+	if graphQLFilter.OperationName != nil {
+		operationName, err := parseRulesWithProps(graphQLFilter.OperationName, true, xPathFilterProps)
+		if err != nil {
+			return nil, err
+		}
+		rule.operationName = *operationName
+	}
+	if graphQLFilter.Variables != nil {
+		variables, err := parseRulesWithProps(graphQLFilter.Variables, true, xPathFilterProps)
+		if err != nil {
+			return nil, err
 		}
+		rule.variables = *variables
+	}
+	return &rule, nil
+}
+
+func parseJwtRule(jwtFilter *JwtFilter, xPathFilterProps *XPathFilterProps) (*JwtRule, error) {
+	keys, err := loadJwtKeys(jwtFilter)
+	if err != nil {
+		return nil, err
+	}
+	headerRule, err := parseJwtClaimRules(jwtFilter.Header, xPathFilterProps)
+	if err != nil {
+		return nil, err
+	}
+	payloadRule, err := parseJwtClaimRules(jwtFilter.Payload, xPathFilterProps)
+	if err != nil {
+		return nil, err
 	}
-	if len(filter.HasExactly) > 0 {
-		for _, filterOr := range filter.Includes {
-			parsedRules, err := parseRuleMulti(&filterOr)
+	return &JwtRule{
+		headerRule:  headerRule,
+		payloadRule: payloadRule,
+		verify:      jwtFilter.Secret != nil || jwtFilter.JwksFile != nil,
+		keys:        keys,
+	}, nil
+}
+
+// parseJwtClaimRules turns claims into JSON path rules. A claim is a JSON
+// path if it starts with $ and a top-level name otherwise.
+func parseJwtClaimRules(claims map[string]Filter, xPathFilterProps *XPathFilterProps) (Rule, error) {
+	var rules []Rule
+	for claim, filter := range claims {
+		path := claim
+		if !strings.HasPrefix(claim, "$") {
+			path = "$[" + strconv.Quote(claim) + "]"
+		}
+		if filter.Absent != nil && *filter.Absent {
+			rule, err := newMatchesJsonPathRule(path, nil)
 			if err != nil {
-				return nil, err
+				return nil, fmt.Errorf("invalid JWT claim %s: %w", claim, err)
 			}
-			rulesOr = append(rulesOr, parsedRules...)
+			rules = append(rules, NotRule{rule})
+			continue
+		}
+		rule, err := XPathJsonFactory{}.generateMatchesXPathRule(&XPathFilter{Expression: path, Filter: filter}, xPathFilterProps)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWT claim %s: %w", claim, err)
 		}
+		rules = append(rules, rule)
 	}
-	return &ParsedRules{rulesAnd, rulesOr}, nil
+	return BlockRule{rulesAnd: rules}, nil
 }
 
-func parseRuleMulti(filter *MultiFilter) ([]Rule, error) {
-	rules := []Rule{}
-	caseInsensitive := false
-	if filter.CaseInsensitive != nil {
-		caseInsensitive = *filter.CaseInsensitive
+func parseDateTimeRule(filter *Filter, actualFormat string) (*DateTimeRule, error) {
+	expectedOffset, err := loadDateTimeOffset(filter.ExpectedOffset, filter.ExpectedOffsetUnit)
+	if err != nil {
+		return nil, err
 	}
-	if filter.Contains != nil {
-		val := *filter.Contains
-		rules = append(rules, ContainsRule{val, caseInsensitive})
+	truncateExpected, err := loadDateTimeTruncation(filter.TruncateExpected)
+	if err != nil {
+		return nil, err
+	}
+	truncateActual, err := loadDateTimeTruncation(filter.TruncateActual)
+	if err != nil {
+		return nil, err
 	}
+	rule := DateTimeRule{
+		before:           filter.Before,
+		after:            filter.After,
+		equalToDateTime:  filter.EqualToDateTime,
+		timeFormat:       actualFormat,
+		truncateExpected: truncateExpected,
+		truncateActual:   truncateActual,
+	}
+	if rule.beforeNow, rule.beforeOffset, err = filter.expectedDateTime("before", expectedOffset); err != nil {
+		return nil, err
+	}
+	if rule.afterNow, rule.afterOffset, err = filter.expectedDateTime("after", expectedOffset); err != nil {
+		return nil, err
+	}
+	if rule.equalToDateTimeNow, rule.equalToDateTimeOffset, err = filter.expectedDateTime("equalToDateTime", expectedOffset); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
 
-	if filter.EqualTo != nil {
-		val := *filter.EqualTo
-		rules = append(rules, EqualToRule{val, caseInsensitive})
+// expectedDateTime tells whether the field is relative to the current time.
+// An inline offset like "now -1 days" replaces expectedOffset for its own
+// field only.
+func (filter *Filter) expectedDateTime(name string, expectedOffset dateTimeOffset) (bool, dateTimeOffset, error) {
+	expression, ok := filter.dateTimeExpressions[name]
+	if !ok {
+		return false, expectedOffset, nil
+	}
+	inlineOffset, _, err := parseNowExpression(expression)
+	if err != nil {
+		return false, dateTimeOffset{}, err
 	}
+	if inlineOffset != (dateTimeOffset{}) {
+		return true, inlineOffset, nil
+	}
+	return true, expectedOffset, nil
+}
 
-	if filter.DoesNotContain != nil {
-		val := *filter.DoesNotContain
-		rules = append(rules, NotRule{ContainsRule{val, caseInsensitive}})
+func parseNumberRule(filter *Filter) (*NumberRule, error) {
+	var rule NumberRule
+	var err error
+	if rule.equalTo, err = filter.EqualToNumber.rat(); err != nil {
+		return nil, err
+	}
+	if rule.greaterThan, err = filter.GreaterThan.rat(); err != nil {
+		return nil, err
+	}
+	if rule.lessThan, err = filter.LessThan.rat(); err != nil {
+		return nil, err
+	}
+	if filter.Tolerance != nil {
+		if *filter.Tolerance < 0 || math.IsNaN(*filter.Tolerance) || math.IsInf(*filter.Tolerance, 0) {
+			return nil, fmt.Errorf("tolerance must be a finite non-negative number: %v", *filter.Tolerance)
+		}
+		rule.tolerance = new(big.Rat).SetFloat64(*filter.Tolerance)
+	}
+	if filter.Between != nil {
+		if filter.Between.Min == nil && filter.Between.Max == nil {
+			return nil, errors.New("between requires min or max")
+		}
+		if rule.min, err = filter.Between.Min.rat(); err != nil {
+			return nil, err
+		}
+		if rule.max, err = filter.Between.Max.rat(); err != nil {
+			return nil, err
+		}
+		if rule.min != nil && rule.max != nil && rule.min.Cmp(rule.max) > 0 {
+			return nil, fmt.Errorf("between min %s is greater than max %s", *filter.Between.Min, *filter.Between.Max)
+		}
+	}
+	return &rule, nil
+}
+
+func (number *Number) rat() (*big.Rat, error) {
+	if number == nil {
+		return nil, nil
+	}
+	rat, ok := parseNumber(string(*number))
+	if !ok {
+		return nil, fmt.Errorf("invalid number: %s", *number)
+	}
+	return rat, nil
+}
+
+func parseRulesMulti(filter *Filter) (*ParsedRules, error) {
+	includes, err := parseRulesList(filter.Includes)
+	if err != nil {
+		return nil, err
+	}
+	hasExactly, err := parseRulesList(filter.HasExactly)
+	if err != nil {
+		return nil, err
+	}
+	return &ParsedRules{includes, hasExactly}, nil
+}
+
+func parseRulesList(filters []MultiFilter) ([]Rule, error) {
+	rules := []Rule{}
+	for _, filter := range filters {
+		rule, err := parseRules(&filter.Filter, true)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, *rule)
 	}
 	return rules, nil
 }
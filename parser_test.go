@@ -1,21 +1,19 @@
 package wiregock
 
 import (
+	"encoding/json"
 	"reflect"
 	"regexp"
-	"strings"
+	"slices"
 	"testing"
 	"time"
-
-	"github.com/antchfx/jsonquery"
-	"github.com/antchfx/xmlquery"
 )
 
 func TestParseRule(t *testing.T) {
 	Contains := "Contains"
 	EqualTo := "EqualTo"
 	CaseInsensitive := false
-	BinaryEqualTo := "BinaryEqualTo"
+	BinaryEqualTo := "QmluYXJ5RXF1YWxUbw=="
 	DoesNotContain := "DoesNotContain"
 	Matches := ".*"
 	DoesNotMatch := ".*"
@@ -83,36 +81,47 @@ func TestParseRule(t *testing.T) {
 		IgnoreArrayOrder:    true,
 		IgnoreExtraElements: true,
 	}
-	equalToJsonNode, err := jsonquery.Parse(strings.NewReader(EqualToJson))
+	equalToJsonValue, err := parseJson(EqualToJson)
 	if err != nil {
 		t.Fatalf(`Wrong example of Json Query: %s`, err)
 	}
-	equalToXmlNode, err := xmlquery.Parse(strings.NewReader(EqualToXml))
+	equalToXmlElement, err := parseXml(EqualToXml)
 	if err != nil {
 		t.Fatalf(`Wrong example of Xml Query: %s`, err)
 	}
 	if err != nil {
 		t.Fatalf(`Wrong example of Xml XPath: %s`, err)
 	}
-	emptyBlockRule := BlockRule{rulesOr: []Rule{}}
 	rulesChecker := RulesChecker{rules, t}
 	rulesToCheck := map[string]Rule{
-		"ContainsRule":          ContainsRule{Contains, CaseInsensitive},
-		"EqualToRule":           EqualToRule{EqualTo, CaseInsensitive},
-		"EqualToBinaryRule":     EqualToBinaryRule{[]byte(BinaryEqualTo)},
-		"NotRule.ContainsRule":  NotRule{ContainsRule{DoesNotContain, CaseInsensitive}},
-		"RegExRule":             RegExRule{regexp.MustCompile(Matches)},
-		"NotRule.RegExRule":     NotRule{RegExRule{regexp.MustCompile(Matches)}},
-		"AbsentRule":            AbsentRule{},
-		"DateTimeRule":          DateTimeRule{&Before, &After, &EqualToDateTime, ActualFormat},
-		"EqualToJsonRule":       EqualToJsonRule{node: equalToJsonNode, EqualToBaseRule: equalToBaseRule},
-		"EqualToXmlRule":        EqualToXmlRule{node: equalToXmlNode, EqualToBaseRule: equalToBaseRule},
-		"EqualToJsonSchemaRule": MatchesJsonSchemaRule{MatchesJsonSchema},
-		"MatchesJsonPathRule":   MatchesJsonPathRule{path: MatchesJsonPath, innerRule: emptyBlockRule},
+		"ContainsRule":         ContainsRule{Contains, CaseInsensitive},
+		"EqualToRule":          EqualToRule{EqualTo, CaseInsensitive},
+		"EqualToBinaryRule":    EqualToBinaryRule{[]byte("BinaryEqualTo")},
+		"NotRule.ContainsRule": NotRule{ContainsRule{DoesNotContain, CaseInsensitive}},
+		"RegExRule":            RegExRule{regexp.MustCompile(Matches)},
+		"NotRule.RegExRule":    NotRule{RegExRule{regexp.MustCompile(Matches)}},
+		"AbsentRule":           AbsentRule{},
+		"DateTimeRule":         DateTimeRule{before: &Before, after: &After, equalToDateTime: &EqualToDateTime, timeFormat: ActualFormat},
+		"EqualToJsonRule":      EqualToJsonRule{value: equalToJsonValue, EqualToBaseRule: equalToBaseRule},
+		"EqualToXmlRule":       EqualToXmlRule{element: equalToXmlElement, EqualToBaseRule: equalToBaseRule},
 	}
 	for key, rule := range rulesToCheck {
 		rulesChecker.checkRule(rule, key)
 	}
+	// compiled paths and schemas aren't comparable, so only the presence is
+	// checked
+	if !slices.ContainsFunc(rules, func(rule Rule) bool {
+		pathRule, ok := rule.(MatchesJsonPathRule)
+		return ok && pathRule.path == MatchesJsonPath && pathRule.evaluable != nil
+	}) {
+		t.Fatalf(`Not parsed: MatchesJsonPathRule`)
+	}
+	if !slices.ContainsFunc(rules, func(rule Rule) bool {
+		schemaRule, ok := rule.(MatchesJsonSchemaRule)
+		return ok && schemaRule.schema != nil
+	}) {
+		t.Fatalf(`Not parsed: EqualToJsonSchemaRule`)
+	}
 }
 
 type RulesChecker struct {
@@ -155,3 +164,318 @@ func TestParseRules(t *testing.T) {
 		t.Fatalf(`Error parsing rule Or: %s`, rulesOr[0])
 	}
 }
+
+func TestParseConditionUrl(t *testing.T) {
+	url, urlPath, urlPattern, urlPathPattern := "/orders?id=1", "/orders", `/orders\?id=\d+`, "/ord.*"
+	context := DataContext{
+		Path:       func() string { return "/orders" },
+		RequestURI: func() string { return "/orders?id=1" },
+	}
+	requestsExpected := map[string]struct {
+		request  MockRequest
+		expected bool
+	}{
+		"url":                     {MockRequest{Url: &url}, true},
+		"url without query":       {MockRequest{Url: &urlPath}, false},
+		"urlPath":                 {MockRequest{UrlPath: &urlPath}, true},
+		"urlPath with query":      {MockRequest{UrlPath: &url}, false},
+		"urlPattern":              {MockRequest{UrlPattern: &urlPattern}, true},
+		"urlPattern partial":      {MockRequest{UrlPattern: &urlPath}, false},
+		"urlPathPattern":          {MockRequest{UrlPathPattern: &urlPathPattern}, true},
+		"urlPathPattern on query": {MockRequest{UrlPathPattern: &urlPattern}, false},
+	}
+	for title, requestExpected := range requestsExpected {
+		parsedConditions, err := ParseCondition(&requestExpected.request, &context)
+		if err != nil {
+			t.Fatalf(`Error parsing %s condition: %s`, title, err)
+		}
+		res, err := parsedConditions.Condition.Check()
+		if err != nil || res != requestExpected.expected {
+			t.Fatalf(`Wrong %s matching: expected %t, got %t. Error: %s`, title, requestExpected.expected, res, err)
+		}
+	}
+	invalidPattern := "/orders/("
+	if _, err := ParseCondition(&MockRequest{UrlPattern: &invalidPattern}, &context); err == nil {
+		t.Fatalf(`Invalid urlPattern accepted`)
+	}
+	for title, request := range map[string]MockRequest{
+		"url":             {Url: &url},
+		"urlPath":         {UrlPath: &urlPath},
+		"urlPathTemplate": {UrlPathTemplate: &urlPath},
+	} {
+		if _, err := ParseCondition(&request, &DataContext{}); err == nil {
+			t.Fatalf(`%s accepted without a DataContext accessor`, title)
+		}
+	}
+	if _, err := ParseCondition(&MockRequest{UrlPath: &urlPath}, &DataContext{RequestURI: context.RequestURI}); err == nil {
+		t.Fatalf(`urlPath accepted without DataContext.Path`)
+	}
+}
+
+func TestParseConditionPathParameters(t *testing.T) {
+	urlPathTemplate, orderId, itemId := "/orders/{orderId}/items/{itemId}", "42", `\d+`
+	request := MockRequest{
+		UrlPathTemplate: &urlPathTemplate,
+		PathParameters: map[string]Filter{
+			"orderId": {EqualTo: &orderId},
+			"itemId":  {Matches: &itemId},
+		},
+	}
+	pathsExpected := map[string]bool{
+		"/orders/42/items/7":   true,
+		"/orders/43/items/7":   false,
+		"/orders/42/items/abc": false,
+		"/orders/42":           false,
+	}
+	for path, expected := range pathsExpected {
+		context := DataContext{Path: func() string { return path }}
+		parsedConditions, err := ParseCondition(&request, &context)
+		if err != nil {
+			t.Fatalf(`Error parsing path parameters: %s`, err)
+		}
+		res, err := parsedConditions.Condition.Check()
+		if err != nil || res != expected {
+			t.Fatalf(`Wrong matching of %s: expected %t, got %t. Error: %s`, path, expected, res, err)
+		}
+	}
+	unknownParameter := MockRequest{UrlPathTemplate: &urlPathTemplate, PathParameters: map[string]Filter{"userId": {EqualTo: &orderId}}}
+	if _, err := ParseCondition(&unknownParameter, &DataContext{}); err == nil {
+		t.Fatalf(`Unknown path parameter accepted`)
+	}
+	malformedTemplate := "/orders/{orderId"
+	if _, err := ParseCondition(&MockRequest{UrlPathTemplate: &malformedTemplate}, &DataContext{}); err == nil {
+		t.Fatalf(`Malformed urlPathTemplate accepted`)
+	}
+}
+
+func TestParseConditionMethod(t *testing.T) {
+	methodsExpected := map[[2]string]bool{
+		{"ANY", "PROPFIND"}:       true,
+		{"GET", "get"}:            true,
+		{"GET, POST", "POST"}:     true,
+		{"GET, POST", "PUT"}:      false,
+		{"PROPFIND", "PROPFIND"}:  true,
+		{"QUERY", "GET"}:          false,
+		{"!DELETE", "GET"}:        true,
+		{"!DELETE", "DELETE"}:     false,
+		{"ANY, !DELETE", "PATCH"}: true,
+	}
+	for methodCase, expected := range methodsExpected {
+		method := methodCase[0]
+		context := DataContext{Method: func() string { return methodCase[1] }}
+		parsedConditions, err := ParseCondition(&MockRequest{Method: &method}, &context)
+		if err != nil {
+			t.Fatalf(`Error parsing method %s: %s`, method, err)
+		}
+		res, err := parsedConditions.Condition.Check()
+		if err != nil || res != expected {
+			t.Fatalf(`Wrong matching of %s by %s: expected %t, got %t. Error: %s`, methodCase[1], method, expected, res, err)
+		}
+	}
+	invalidMethod := "GET POST"
+	if _, err := ParseCondition(&MockRequest{Method: &invalidMethod}, &DataContext{}); err == nil {
+		t.Fatalf(`Invalid method accepted`)
+	}
+	getMethod, anyMethod := "GET", "ANY"
+	if _, err := ParseCondition(&MockRequest{Method: &getMethod}, &DataContext{}); err == nil {
+		t.Fatalf(`Method accepted without DataContext.Method`)
+	}
+	if _, err := ParseCondition(&MockRequest{Method: &anyMethod}, &DataContext{}); err != nil {
+		t.Fatalf(`ANY method requires DataContext.Method: %s`, err)
+	}
+}
+
+func TestParseConditionMatchingType(t *testing.T) {
+	urlPath, accept, search, body := "/search", "xml", "WireMock", "needle"
+	context := DataContext{
+		Path:   func() string { return "/search" },
+		Get:    func(key string) string { return "application/json" },
+		Params: func(key string) string { return "WireMock" },
+		Body:   func() string { return "haystack" },
+	}
+	matchingTypesExpected := map[string]bool{"ALL": false, "ANY": true, "any": true}
+	for matchingType, expected := range matchingTypesExpected {
+		request := MockRequest{
+			UrlPath:         &urlPath,
+			MatchingType:    &matchingType,
+			Headers:         map[string]Filter{"Accept": {Contains: &accept}},
+			QueryParameters: map[string]Filter{"search_term": {EqualTo: &search}},
+			BodyPatterns:    []Filter{{Contains: &body}},
+		}
+		parsedConditions, err := ParseCondition(&request, &context)
+		if err != nil {
+			t.Fatalf(`Error parsing conditions: %s`, err)
+		}
+		res, err := parsedConditions.Condition.Check()
+		if err != nil || res != expected {
+			t.Fatalf(`Wrong matching with matchingType %s: expected %t, got %t. Error: %s`, matchingType, expected, res, err)
+		}
+	}
+	otherPath, matchingType := "/other", "ANY"
+	request := MockRequest{UrlPath: &otherPath, MatchingType: &matchingType, QueryParameters: map[string]Filter{"search_term": {EqualTo: &search}}}
+	parsedConditions, err := ParseCondition(&request, &context)
+	if err != nil {
+		t.Fatalf(`Error parsing conditions: %s`, err)
+	}
+	if res, err := parsedConditions.Condition.Check(); err != nil || res {
+		t.Fatalf(`matchingType ANY ignored URL mismatch. Error: %s`, err)
+	}
+	before, ok := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), "ok"
+	headers := map[string]string{"A": "not a date", "B": "ok"}
+	request = MockRequest{
+		MatchingType: &matchingType,
+		Headers:      map[string]Filter{"A": {Before: &before}, "B": {EqualTo: &ok}},
+	}
+	for i := 0; i < 20; i++ {
+		parsedConditions, err := ParseCondition(&request, &DataContext{Get: func(key string) string { return headers[key] }})
+		if err != nil {
+			t.Fatalf(`Error parsing conditions: %s`, err)
+		}
+		if res, err := parsedConditions.Condition.Check(); err != nil || !res {
+			t.Fatalf(`matchingType ANY stopped at a failing header. Error: %s`, err)
+		}
+	}
+	invalidMatchingType := "SOME"
+	if _, err := ParseCondition(&MockRequest{MatchingType: &invalidMatchingType}, &context); err == nil {
+		t.Fatalf(`Invalid matchingType accepted`)
+	}
+}
+
+func TestParseConditionMulti(t *testing.T) {
+	var filters map[string]Filter
+	err := json.Unmarshal([]byte(`{
+		"includes": {"includes": [{"equalTo": "a"}, {"matches": "b\\d"}]},
+		"hasExactly": {"hasExactly": [{"equalTo": "a"}, {"contains": "b"}, {"matches": "[a-z]\\d"}]},
+		"hasExactlyJson": {"hasExactly": [{"equalToJson": "{\"id\": 1}"}]},
+		"includesMissing": {"includes": [{"doesNotContain": "x"}]},
+		"hasExactlyMissing": {"hasExactly": [{"doesNotContain": "x"}]}
+	}`), &filters)
+	if err != nil {
+		t.Fatalf(`Error parsing JSON format: %s`, err)
+	}
+	valuesExpected := []struct {
+		key      string
+		values   []string
+		expected bool
+	}{
+		{"includes", []string{"b1", "c", "a"}, true},
+		{"includes", []string{"a", "c"}, false},
+		{"hasExactly", []string{"b1", "a", "c2"}, true},
+		{"hasExactly", []string{"b1", "a"}, false},
+		{"hasExactly", []string{"b1", "a", "c2", "d"}, false},
+		{"hasExactly", []string{"b", "a", "bb"}, false},
+		{"hasExactlyJson", []string{`{"id": 1.0}`}, true},
+		{"hasExactlyJson", []string{"not json"}, false},
+		{"includesMissing", nil, false},
+		{"hasExactlyMissing", nil, false},
+		{"hasExactlyMissing", []string{"a"}, true},
+	}
+	for _, valueExpected := range valuesExpected {
+		filter := filters[valueExpected.key]
+		condition, err := createConditionMulti(&filter, "query", valueExpected.key, func() []string { return valueExpected.values })
+		if err != nil {
+			t.Fatalf(`Error parsing %s: %s`, valueExpected.key, err)
+		}
+		res, err := condition.Check()
+		if err != nil || res != valueExpected.expected {
+			t.Fatalf(`Wrong %s matching of %v: expected %t, got %t. Error: %s`, valueExpected.key, valueExpected.values, valueExpected.expected, res, err)
+		}
+	}
+}
+
+func TestParseConditionFormParameters(t *testing.T) {
+	var request MockRequest
+	err := json.Unmarshal([]byte(`{
+		"formParameters": {
+			"name": {"equalTo": "John Doe"},
+			"tag": {"hasExactly": [{"equalTo": "a"}, {"equalTo": "b&c"}]},
+			"token": {"absent": true}
+		}
+	}`), &request)
+	if err != nil {
+		t.Fatalf(`Error parsing JSON format: %s`, err)
+	}
+	valuesExpected := []struct {
+		contentType string
+		body        string
+		expected    bool
+	}{
+		{"application/x-www-form-urlencoded", "name=John+Doe&tag=a&tag=b%26c", true},
+		{"application/x-www-form-urlencoded; charset=UTF-8", "tag=b%26c&name=John%20Doe&tag=a\n", true},
+		{"", "name=John+Doe&tag=a&tag=b%26c", true},
+		{"application/x-www-form-urlencoded", "name=John+Doe&tag=a", false},
+		{"application/x-www-form-urlencoded", "name=John+Doe&tag=a&tag=b%26c&tag=d", false},
+		{"application/x-www-form-urlencoded", "name=John+Doe&tag=a&tag=b%26c&token=1", false},
+		{"application/json", "name=John+Doe&tag=a&tag=b%26c", false},
+	}
+	for _, valueExpected := range valuesExpected {
+		context := DataContext{
+			Body: func() string { return valueExpected.body },
+			Get:  func(key string) string { return valueExpected.contentType },
+		}
+		parsedConditions, err := ParseCondition(&request, &context)
+		if err != nil {
+			t.Fatalf(`Error parsing conditions: %s`, err)
+		}
+		res, err := parsedConditions.Condition.Check()
+		if err != nil || res != valueExpected.expected {
+			t.Fatalf(`Wrong matching of %s: expected %t, got %t. Error: %s`, valueExpected.body, valueExpected.expected, res, err)
+		}
+	}
+	context := DataContext{
+		Body:       func() string { return "" },
+		FormValues: func(key string) []string { return map[string][]string{"name": {"John Doe"}, "tag": {"a", "b&c"}}[key] },
+	}
+	parsedConditions, err := ParseCondition(&request, &context)
+	if err != nil {
+		t.Fatalf(`Error parsing conditions: %s`, err)
+	}
+	if res, err := parsedConditions.Condition.Check(); err != nil || !res {
+		t.Fatalf(`FormValues aren't used for matching. Error: %s`, err)
+	}
+}
+
+func TestParseRuleNumber(t *testing.T) {
+	valuesExpected := []struct {
+		filter   string
+		value    string
+		expected bool
+	}{
+		{`{"greaterThan": 10}`, "11", true},
+		{`{"greaterThan": 10, "lessThan": 12}`, "12", false},
+		{`{"between": {"min": 1, "max": 2.5}}`, "2.5", true},
+		{`{"equalToNumber": 0.3, "tolerance": 0.0001}`, "0.30000001", true},
+		{`{"equalToNumber": 9007199254740993}`, "9007199254740992", false},
+		{`{"equalToNumber": "9007199254740993"}`, "9007199254740993", true},
+		{`{"between": {"min": 9007199254740993, "max": 9007199254740995}}`, "9007199254740992", false},
+		{`{"matchesJsonPath": {"expression": "$..quantity", "greaterThan": 10}}`, `{"quantity": 11}`, true},
+		{`{"matchesJsonPath": {"expression": "$..quantity", "greaterThan": 10}}`, `{"quantity": 9}`, false},
+		{`{"matchesXPath": {"expression": "//quantity/text()", "lessThan": 10}}`, `<order><quantity>9</quantity></order>`, true},
+	}
+	for _, valueExpected := range valuesExpected {
+		var filter Filter
+		if err := json.Unmarshal([]byte(valueExpected.filter), &filter); err != nil {
+			t.Fatalf(`Error parsing %s: %s`, valueExpected.filter, err)
+		}
+		rules, err := parseRule(&filter)
+		if err != nil {
+			t.Fatalf(`Error creating rule for %s: %s`, valueExpected.filter, err)
+		}
+		res, err := BlockRule{rulesAnd: rules}.Check(valueExpected.value)
+		if err != nil || res != valueExpected.expected {
+			t.Fatalf(`Wrong %s matching of %s: expected %t, got %t. Error: %s`, valueExpected.filter, valueExpected.value, valueExpected.expected, res, err)
+		}
+	}
+	for _, invalid := range []string{`{"between": {}}`, `{"between": {"min": 2, "max": 1}}`, `{"equalToNumber": 1, "tolerance": -1}`} {
+		var filter Filter
+		if err := json.Unmarshal([]byte(invalid), &filter); err != nil {
+			t.Fatalf(`Error parsing %s: %s`, invalid, err)
+		}
+		if _, err := parseRule(&filter); err == nil {
+			t.Fatalf(`Invalid filter %s accepted`, invalid)
+		}
+	}
+	if err := json.Unmarshal([]byte(`{"equalToNumber": "ten"}`), &Filter{}); err == nil {
+		t.Fatalf(`Invalid number accepted`)
+	}
+}
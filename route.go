@@ -1,19 +1,134 @@
 package wiregock
 
 import (
-    "strings"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
 )
 
-var anyMethods = [...]string{ "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE", "POST", "PATCH", "CONNECT" }
+var anyMethods = [...]string{
+	"GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE", "POST", "PATCH", "CONNECT", "QUERY",
+	"PROPFIND", "PROPPATCH", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK", "REPORT", "SEARCH",
+}
 
+var regExMethodName = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// LoadMethods lists the methods to register a route for. Negated methods
+// ("!DELETE") are removed from the listed ones or from ANY when only
+// negations are given. A list which doesn't parse is split by commas as it
+// is, so the route is still registered for the listed names.
 func LoadMethods(methodNames string) []string {
-    if strings.Compare(methodNames, "ANY") == 0 {
-        return anyMethods[:]
-    }
-    splitRaw := strings.Split(methodNames, ",")
-    splitResult := []string{}
-    for _, splitted := range splitRaw {
-    	splitResult = append(splitResult, strings.ToUpper(strings.Trim(splitted, " ")))
-    }
-    return splitResult
-}
\ No newline at end of file
+	methodRule, err := parseMethodRule(methodNames)
+	if err != nil {
+		splitResult := []string{}
+		for _, splitted := range strings.Split(methodNames, ",") {
+			splitResult = append(splitResult, strings.ToUpper(strings.Trim(splitted, " ")))
+		}
+		return splitResult
+	}
+	methods := methodRule.methods
+	if methods == nil {
+		methods = anyMethods[:]
+	}
+	splitResult := []string{}
+	for _, method := range methods {
+		if !slices.Contains(methodRule.excluded, method) {
+			splitResult = append(splitResult, method)
+		}
+	}
+	return splitResult
+}
+
+func parseMethodRule(methodNames string) (*MethodRule, error) {
+	methodRule := MethodRule{}
+	anyMethod := false
+	for _, splitted := range strings.Split(methodNames, ",") {
+		method := strings.ToUpper(strings.Trim(splitted, " "))
+		excluded := strings.HasPrefix(method, "!")
+		method = strings.TrimPrefix(method, "!")
+		if !regExMethodName.MatchString(method) || strings.HasPrefix(method, "!") {
+			return nil, fmt.Errorf("invalid method name: %q", splitted)
+		}
+		switch {
+		case excluded:
+			methodRule.excluded = append(methodRule.excluded, method)
+		case method == "ANY":
+			anyMethod = true
+		default:
+			methodRule.methods = append(methodRule.methods, method)
+		}
+	}
+	if anyMethod {
+		methodRule.methods = nil
+	}
+	return &methodRule, nil
+}
+
+var regExPathParameterName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+type PathTemplate struct {
+	template   string
+	regex      *regexp.Regexp
+	parameters []string
+}
+
+func ParsePathTemplate(template string) (*PathTemplate, error) {
+	if !strings.HasPrefix(template, "/") {
+		return nil, fmt.Errorf("path template %s must start with /", template)
+	}
+	var regexStr strings.Builder
+	regexStr.WriteString("^")
+	parameters := []string{}
+	rest := template
+	for len(rest) > 0 {
+		start := strings.IndexAny(rest, "{}")
+		if start < 0 {
+			regexStr.WriteString(regexp.QuoteMeta(rest))
+			break
+		}
+		if rest[start] == '}' {
+			return nil, fmt.Errorf("path template %s has unexpected }", template)
+		}
+		regexStr.WriteString(regexp.QuoteMeta(rest[:start]))
+		end := strings.IndexAny(rest[start+1:], "{}")
+		if end < 0 || rest[start+1+end] == '{' {
+			return nil, fmt.Errorf("path template %s has unclosed {", template)
+		}
+		name := rest[start+1 : start+1+end]
+		if !regExPathParameterName.MatchString(name) {
+			return nil, fmt.Errorf("path template %s has invalid parameter name %q", template, name)
+		}
+		if slices.Contains(parameters, name) {
+			return nil, fmt.Errorf("path template %s has duplicate parameter %s", template, name)
+		}
+		rest = rest[start+end+2:]
+		if strings.HasPrefix(rest, "{") {
+			return nil, fmt.Errorf("path template %s has adjacent parameters", template)
+		}
+		parameters = append(parameters, name)
+		regexStr.WriteString("([^/]+)")
+	}
+	regexStr.WriteString("$")
+	regex, err := regexp.Compile(regexStr.String())
+	if err != nil {
+		return nil, err
+	}
+	return &PathTemplate{template, regex, parameters}, nil
+}
+
+func (pathTemplate *PathTemplate) Parameters() []string {
+	return pathTemplate.parameters
+}
+
+func (pathTemplate *PathTemplate) Match(path string) (map[string]string, bool) {
+	match := pathTemplate.regex.FindStringSubmatch(path)
+	if match == nil {
+		return nil, false
+	}
+	values := map[string]string{}
+	for index, name := range pathTemplate.parameters {
+		values[name] = match[index+1]
+	}
+	return values, true
+}
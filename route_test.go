@@ -1,22 +1,58 @@
 package wiregock
 
 import (
-	"testing"
 	"slices"
+	"testing"
 )
 
 func TestLoadMethodsCheck(t *testing.T) {
 	methods := LoadMethods("ANY")
 	for _, method := range anyMethods {
 		if !slices.Contains(methods, method) {
-        	t.Fatalf(`%s method isn't loaded from ANY`, method)
-    	}
+			t.Fatalf(`%s method isn't loaded from ANY`, method)
+		}
 	}
 	methodsGetPost := LoadMethods("GET, POST")
 	if !slices.Contains(methodsGetPost, "GET") {
-    	t.Fatalf(`%s method isn't loaded from "GET, POST"`, "GET")
+		t.Fatalf(`%s method isn't loaded from "GET, POST"`, "GET")
 	}
 	if !slices.Contains(methodsGetPost, "POST") {
-    	t.Fatalf(`%s method isn't loaded by ANY`, "POST")
+		t.Fatalf(`%s method isn't loaded by ANY`, "POST")
+	}
+}
+
+func TestParsePathTemplate(t *testing.T) {
+	pathTemplate, err := ParsePathTemplate("/orders/{orderId}/items/{itemId}")
+	if err != nil {
+		t.Fatalf(`Error parsing path template: %s`, err)
+	}
+	values, ok := pathTemplate.Match("/orders/42/items/a-1")
+	if !ok || values["orderId"] != "42" || values["itemId"] != "a-1" {
+		t.Fatalf(`Wrong path parameters: %v`, values)
+	}
+	for _, path := range []string{"/orders/42/items", "/orders/42/items/1/2", "/orders//items/1"} {
+		if _, ok := pathTemplate.Match(path); ok {
+			t.Fatalf(`Path %s matched template`, path)
+		}
+	}
+	for _, template := range []string{"orders/{id}", "/orders/{id", "/orders/id}", "/orders/{}", "/orders/{1d}", "/{id}/{id}", "/{a}{b}", "/{a{b}}"} {
+		if _, err := ParsePathTemplate(template); err == nil {
+			t.Fatalf(`Malformed path template %s accepted`, template)
+		}
+	}
+}
+
+func TestLoadMethodsNegation(t *testing.T) {
+	methods := LoadMethods("!DELETE")
+	if slices.Contains(methods, "DELETE") || !slices.Contains(methods, "GET") || !slices.Contains(methods, "PROPFIND") {
+		t.Fatalf(`Wrong methods loaded from "!DELETE": %v`, methods)
+	}
+	methods = LoadMethods("GET, POST, !POST")
+	if !slices.Equal(methods, []string{"GET"}) {
+		t.Fatalf(`Wrong methods loaded from "GET, POST, !POST": %v`, methods)
+	}
+	methods = LoadMethods("get, GET POST")
+	if !slices.Equal(methods, []string{"GET", "GET POST"}) {
+		t.Fatalf(`Invalid method list isn't split as it is: %v`, methods)
 	}
-}
\ No newline at end of file
+}
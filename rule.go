@@ -2,24 +2,25 @@ package wiregock
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
+	"crypto/sha256"
 	"errors"
-	"fmt"
-	"reflect"
+	"math/big"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/IGLOU-EU/go-wildcard/v2"
-	"github.com/PaesslerAG/jsonpath"
-	"github.com/antchfx/jsonquery"
+	"github.com/PaesslerAG/gval"
 	"github.com/antchfx/xmlquery"
 	"github.com/antchfx/xpath"
-	"github.com/xeipuuv/gojsonschema"
+	"github.com/santhosh-tekuri/jsonschema/v5"
 )
 
 type Rule interface {
-	check(str string) (bool, error)
+	Check(str string) (bool, error)
 }
 
 type NotRule struct {
@@ -35,11 +36,40 @@ type EqualToBinaryRule struct {
 	val []byte
 }
 
+type StartsWithBytesRule struct {
+	val []byte
+}
+
+type ContainsBytesRule struct {
+	val []byte
+}
+
+type Sha256EqualToRule struct {
+	val []byte
+}
+
 type DateTimeRule struct {
-	before          *time.Time
-	after           *time.Time
-	equalToDateTime *time.Time
-	timeFormat      string //default: time.RFC3339
+	before                *time.Time
+	after                 *time.Time
+	equalToDateTime       *time.Time
+	timeFormat            string //default: time.RFC3339
+	beforeNow             bool
+	afterNow              bool
+	equalToDateTimeNow    bool
+	beforeOffset          dateTimeOffset
+	afterOffset           dateTimeOffset
+	equalToDateTimeOffset dateTimeOffset
+	truncateExpected      string
+	truncateActual        string
+}
+
+type NumberRule struct {
+	equalTo     *big.Rat
+	greaterThan *big.Rat
+	lessThan    *big.Rat
+	min         *big.Rat
+	max         *big.Rat
+	tolerance   *big.Rat // nil for exact comparison
 }
 
 type ContainsRule struct {
@@ -63,10 +93,11 @@ type MatchesXmlXPathRule struct {
 type MatchesJsonPathRule struct {
 	path      string
 	innerRule Rule
+	evaluable gval.Evaluable // compiled path, nil until the rule is parsed
 }
 
 type MatchesJsonSchemaRule struct {
-	schema string
+	schema *jsonschema.Schema
 }
 
 type EqualToBaseRule struct {
@@ -75,15 +106,38 @@ type EqualToBaseRule struct {
 }
 
 type EqualToXmlRule struct {
-	node *xmlquery.Node
+	element *xmlElement
 	EqualToBaseRule
+	XmlComparisonProps
 }
 
 type EqualToJsonRule struct {
-	node *jsonquery.Node
+	value interface{}
 	EqualToBaseRule
 }
 
+type GraphQLRule struct {
+	query         *string
+	operationName Rule
+	variables     Rule
+}
+
+type JwtRule struct {
+	headerRule  Rule
+	payloadRule Rule
+	verify      bool
+	keys        []jwtKey
+}
+
+type MethodRule struct {
+	methods  []string
+	excluded []string
+}
+
+type PathTemplateRule struct {
+	pathTemplate *PathTemplate
+}
+
 type AbsentRule struct {
 }
 
@@ -98,82 +152,183 @@ type BlockRule struct {
 	rulesOr  []Rule
 }
 
-func (rule NotRule) check(str string) (bool, error) {
-	res, err := rule.base.check(str)
+func (rule NotRule) Check(str string) (bool, error) {
+	return rule.checkBody(newRequestBody(str))
+}
+
+func (rule NotRule) checkBody(body *requestBody) (bool, error) {
+	res, err := checkBody(rule.base, body)
 	return !res, err
 }
 
-func (rule EqualToRule) check(str string) (bool, error) {
+func (rule EqualToRule) Check(str string) (bool, error) {
 	if rule.caseInsensitive {
 		return strings.EqualFold(rule.val, str), nil
 	}
 	return strings.Compare(rule.val, str) == 0, nil
 }
 
-func (rule EqualToBinaryRule) check(str string) (bool, error) {
+func (rule EqualToBinaryRule) Check(str string) (bool, error) {
 	return bytes.Equal(rule.val, []byte(str)), nil
 }
 
-func (rule DateTimeRule) check(str string) (bool, error) {
-	sourceTime, error := time.Parse(rule.timeFormat, str)
+func (rule EqualToBinaryRule) checkBody(body *requestBody) (bool, error) {
+	return bytes.Equal(rule.val, body.bytes()), nil
+}
+
+func (rule StartsWithBytesRule) Check(str string) (bool, error) {
+	return strings.HasPrefix(str, string(rule.val)), nil
+}
+
+func (rule StartsWithBytesRule) checkBody(body *requestBody) (bool, error) {
+	return bytes.HasPrefix(body.bytes(), rule.val), nil
+}
+
+func (rule ContainsBytesRule) Check(str string) (bool, error) {
+	return strings.Contains(str, string(rule.val)), nil
+}
+
+func (rule ContainsBytesRule) checkBody(body *requestBody) (bool, error) {
+	return bytes.Contains(body.bytes(), rule.val), nil
+}
+
+func (rule Sha256EqualToRule) Check(str string) (bool, error) {
+	sum := sha256.Sum256([]byte(str))
+	return bytes.Equal(rule.val, sum[:]), nil
+}
+
+func (rule Sha256EqualToRule) checkBody(body *requestBody) (bool, error) {
+	sum := sha256.Sum256(body.bytes())
+	return bytes.Equal(rule.val, sum[:]), nil
+}
+
+func (rule DateTimeRule) Check(str string) (bool, error) {
+	sourceTime, error := parseActualDateTime(rule.timeFormat, str)
 	if error != nil {
 		return false, error
 	}
-	if rule.equalToDateTime != nil && !sourceTime.Equal(*rule.equalToDateTime) {
+	sourceTime = truncateDateTime(sourceTime, rule.truncateActual)
+	if rule.equalToDateTime != nil && !sourceTime.Equal(rule.expected(*rule.equalToDateTime, rule.equalToDateTimeNow, rule.equalToDateTimeOffset)) {
+		return false, nil
+	}
+	if rule.before != nil && !sourceTime.Before(rule.expected(*rule.before, rule.beforeNow, rule.beforeOffset)) {
+		return false, nil
+	}
+	if rule.after != nil && !sourceTime.After(rule.expected(*rule.after, rule.afterNow, rule.afterOffset)) {
+		return false, nil
+	}
+	return true, nil
+}
+
+// expected takes the current time instead of dateTime if now is set, then
+// applies the offset and truncation.
+func (rule DateTimeRule) expected(dateTime time.Time, now bool, offset dateTimeOffset) time.Time {
+	if now {
+		dateTime = time.Now().UTC()
+	}
+	return truncateDateTime(offset.apply(dateTime), rule.truncateExpected)
+}
+
+// check treats values which aren't finite numbers as a mismatch rather than
+// an error, since any header or query parameter may hold arbitrary text.
+func (rule NumberRule) Check(str string) (bool, error) {
+	number, ok := parseNumber(str)
+	if !ok {
+		return false, nil
+	}
+	if rule.equalTo != nil && new(big.Rat).Abs(new(big.Rat).Sub(number, rule.equalTo)).Cmp(rule.tolerated()) > 0 {
+		return false, nil
+	}
+	if rule.greaterThan != nil && number.Cmp(rule.greaterThan) <= 0 {
+		return false, nil
+	}
+	if rule.lessThan != nil && number.Cmp(rule.lessThan) >= 0 {
 		return false, nil
 	}
-	if rule.before != nil && !sourceTime.Before(*rule.before) {
+	if rule.min != nil && number.Cmp(new(big.Rat).Sub(rule.min, rule.tolerated())) < 0 {
 		return false, nil
 	}
-	if rule.after != nil && !sourceTime.After(*rule.after) {
+	if rule.max != nil && number.Cmp(new(big.Rat).Add(rule.max, rule.tolerated())) > 0 {
 		return false, nil
 	}
 	return true, nil
 }
 
-func (rule ContainsRule) check(str string) (bool, error) {
+func (rule NumberRule) tolerated() *big.Rat {
+	if rule.tolerance == nil {
+		return new(big.Rat)
+	}
+	return rule.tolerance
+}
+
+// parseNumber reads a decimal integer or decimal number, with an optional
+// exponent, exactly. Infinities and NaN aren't numbers.
+func parseNumber(str string) (*big.Rat, bool) {
+	str = strings.TrimSpace(str)
+	if _, err := strconv.ParseFloat(str, 64); err != nil && !errors.Is(err, strconv.ErrRange) {
+		return nil, false
+	}
+	if strings.ContainsAny(str, "xXpP_") {
+		return nil, false
+	}
+	return new(big.Rat).SetString(str)
+}
+
+func (rule ContainsRule) Check(str string) (bool, error) {
 	if rule.caseInsensitive {
 		return strings.Contains(strings.ToLower(str), strings.ToLower(rule.val)), nil
 	}
 	return strings.Contains(str, rule.val), nil
 }
 
-func (rule WildcardsRule) check(str string) (bool, error) {
+func (rule WildcardsRule) Check(str string) (bool, error) {
 	if rule.caseInsensitive {
 		return wildcard.Match(strings.ToLower(rule.val), strings.ToLower(str)), nil
 	}
 	return wildcard.Match(rule.val, str), nil
 }
 
-func (rule RegExRule) check(str string) (bool, error) {
+func (rule RegExRule) Check(str string) (bool, error) {
 	return rule.regex.MatchString(str), nil
 }
 
-func (rule EqualToXmlRule) check(str string) (bool, error) {
-	node, err := xmlquery.Parse(strings.NewReader(str))
+func (rule EqualToXmlRule) Check(str string) (bool, error) {
+	return rule.checkBody(newRequestBody(str))
+}
+
+func (rule EqualToXmlRule) checkBody(body *requestBody) (bool, error) {
+	element, err := body.xml()
 	if err != nil {
 		return false, err
 	}
-	return reflect.DeepEqual(*rule.node, *node), nil
+	return equalXml(rule.element, element, rule.EqualToBaseRule, rule.XmlComparisonProps), nil
 }
 
-func (rule EqualToJsonRule) check(str string) (bool, error) {
-	node, err := jsonquery.Parse(strings.NewReader(str))
+func (rule EqualToJsonRule) Check(str string) (bool, error) {
+	return rule.checkBody(newRequestBody(str))
+}
+
+func (rule EqualToJsonRule) checkBody(body *requestBody) (bool, error) {
+	value, err := body.json()
 	if err != nil {
 		return false, err
 	}
-	return reflect.DeepEqual(*rule.node, *node), nil
+	return equalJson(rule.value, value, rule.EqualToBaseRule), nil
+}
+
+func (rule MatchesXmlXPathRule) Check(str string) (bool, error) {
+	return rule.checkBody(newRequestBody(str))
 }
 
-func (rule MatchesXmlXPathRule) check(str string) (bool, error) {
-	nodeBase, err := xmlquery.Parse(strings.NewReader(str))
+func (rule MatchesXmlXPathRule) checkBody(body *requestBody) (bool, error) {
+	nodeBase, err := body.xmlQueryNode()
 	if err != nil {
 		return false, err
 	}
 	if rule.innerRule != nil {
 		nodesByXPath := xmlquery.QuerySelectorAll(nodeBase, rule.xPath)
 		for _, node := range nodesByXPath {
-			ok, err := rule.innerRule.check(node.OutputXML(true))
+			ok, err := rule.innerRule.Check(node.OutputXML(true))
 			if err != nil {
 				return false, err
 			}
@@ -186,61 +341,165 @@ func (rule MatchesXmlXPathRule) check(str string) (bool, error) {
 	return (xmlquery.QuerySelector(nodeBase, rule.xPath) != nil), nil
 }
 
-func (rule MatchesJsonPathRule) check(str string) (bool, error) {
-	v := interface{}(nil)
-	json.Unmarshal([]byte(str), &v)
-	result, err := jsonpath.Get(rule.path, v)
+// check treats invalid JSON and a path selecting nothing as a mismatch. The
+// innerRule gets strings as they are and other values as canonical JSON:
+// the selected value itself and, when it's an array, each of its items.
+func (rule MatchesJsonPathRule) Check(str string) (bool, error) {
+	return rule.checkBody(newRequestBody(str))
+}
+
+func (rule MatchesJsonPathRule) checkBody(body *requestBody) (bool, error) {
+	v, err := body.jsonPath()
+	if err != nil {
+		return false, nil
+	}
+	evaluable := rule.evaluable
+	if evaluable == nil {
+		if evaluable, err = compileJsonPath(rule.path); err != nil {
+			return false, err
+		}
+	}
+	result, err := evaluable(context.Background(), v)
+	if err != nil || result == nil {
+		return false, nil
+	}
+	values := []interface{}{result}
+	if items, ok := result.([]interface{}); ok {
+		if !isDefiniteJsonPath(rule.path) {
+			values = nil
+		}
+		values = append(values, items...)
+	}
+	if rule.innerRule == nil {
+		return len(values) > 0, nil
+	}
+	for _, value := range values {
+		valueStr, err := jsonValueString(value)
+		if err != nil {
+			return false, err
+		}
+		ok, err := rule.innerRule.Check(valueStr)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (rule MatchesJsonSchemaRule) Check(str string) (bool, error) {
+	return rule.checkBody(newRequestBody(str))
+}
+
+func (rule MatchesJsonSchemaRule) checkBody(body *requestBody) (bool, error) {
+	value, err := body.json()
 	if err != nil {
 		return false, err
 	}
-	if rule.innerRule != nil {
-		for _, value := range result.([]interface{}) {
-			valueStr := fmt.Sprintf("%s", value)
-			ok, err := rule.innerRule.check(valueStr)
-			if err != nil {
+	if err := rule.schema.Validate(value); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (rule GraphQLRule) Check(str string) (bool, error) {
+	return rule.checkBody(newRequestBody(str))
+}
+
+// checkBody reads a JSON request with query, operationName and variables,
+// or else takes the whole body as the query (application/graphql).
+func (rule GraphQLRule) checkBody(body *requestBody) (bool, error) {
+	query, operationName, variables, hasVariables := body.raw, "", "", false
+	if value, err := body.json(); err == nil {
+		request, ok := value.(map[string]interface{})
+		if !ok {
+			return false, nil
+		}
+		if query, ok = request["query"].(string); !ok {
+			return false, nil
+		}
+		operationName, _ = request["operationName"].(string)
+		if request["variables"] != nil {
+			if variables, err = jsonValueString(request["variables"]); err != nil {
 				return false, err
 			}
-			if ok {
-				return true, nil
-			}
+			hasVariables = true
+		}
+	}
+	if rule.query != nil {
+		normalized, err := normalizeGraphQL(query)
+		if err != nil || normalized != *rule.query {
+			return false, nil
+		}
+	}
+	if rule.operationName != nil {
+		res, err := rule.operationName.Check(operationName)
+		if err != nil || !res {
+			return false, err
 		}
 	}
-	return result != nil, nil
+	if rule.variables == nil {
+		return true, nil
+	}
+	if !hasVariables {
+		// only absent accepts missing variables, the other matchers may fail
+		// to read an empty value
+		return checkQuietly(rule.variables, ""), nil
+	}
+	return checkBody(rule.variables, newRequestBody(variables))
 }
 
-func (rule MatchesJsonSchemaRule) check(str string) (bool, error) {
-	schemaLoader := gojsonschema.NewStringLoader(rule.schema)
-	documentLoader := gojsonschema.NewStringLoader(str)
-	result, err := gojsonschema.Validate(schemaLoader, documentLoader)
+// Check treats a malformed token or an invalid signature as a mismatch.
+func (rule JwtRule) Check(str string) (bool, error) {
+	token, err := parseJwt(str)
 	if err != nil {
-		return false, err
+		return false, nil
 	}
-	if result.Valid() {
-		return true, nil
+	if rule.verify && !verifyJwt(token, rule.keys) {
+		return false, nil
+	}
+	res, err := checkBody(rule.headerRule, newRequestBody(token.header))
+	if err != nil || !res {
+		return false, err
 	}
-	var errs []error
-	for _, desc := range result.Errors() {
-		errs = append(errs, errors.New(desc.Description()))
+	return checkBody(rule.payloadRule, newRequestBody(token.payload))
+}
+
+func (rule MethodRule) Check(str string) (bool, error) {
+	method := strings.ToUpper(str)
+	if slices.Contains(rule.excluded, method) {
+		return false, nil
 	}
-	return false, errors.Join(errs...)
+	return rule.methods == nil || slices.Contains(rule.methods, method), nil
+}
+
+func (rule PathTemplateRule) Check(str string) (bool, error) {
+	_, ok := rule.pathTemplate.Match(str)
+	return ok, nil
 }
 
-func (rule AbsentRule) check(str string) (bool, error) {
+func (rule AbsentRule) Check(str string) (bool, error) {
 	return len(str) == 0, nil
 }
 
-func (rule TrueRule) check(str string) (bool, error) {
+func (rule TrueRule) Check(str string) (bool, error) {
 	return true, nil
 }
 
-func (rule FalseRule) check(str string) (bool, error) {
+func (rule FalseRule) Check(str string) (bool, error) {
 	return false, nil
 }
 
-func (rule BlockRule) check(str string) (bool, error) {
+func (rule BlockRule) Check(str string) (bool, error) {
+	return rule.checkBody(newRequestBody(str))
+}
+
+func (rule BlockRule) checkBody(body *requestBody) (bool, error) {
 	if rule.rulesAnd != nil {
 		for _, ruleAnd := range rule.rulesAnd {
-			res, err := ruleAnd.check(str)
+			res, err := checkBody(ruleAnd, body)
 			if err != nil {
 				return false, err
 			}
@@ -252,7 +511,7 @@ func (rule BlockRule) check(str string) (bool, error) {
 	resultAnd := rule.rulesAnd == nil || len(rule.rulesAnd) > 0
 	if rule.rulesOr != nil {
 		for _, ruleOr := range rule.rulesOr {
-			res, err := ruleOr.check(str)
+			res, err := checkBody(ruleOr, body)
 			if err != nil {
 				return false, err
 			}
@@ -271,3 +530,161 @@ func generateXPath(str string, namespaces map[string]string) (*xpath.Expr, error
 	}
 	return xpath.Compile(str)
 }
+
+// NewRule builds the rules of a filter, the same way a stub's matcher is
+// parsed. It covers the options the other constructors don't take.
+func NewRule(filter *Filter) (Rule, error) {
+	rule, err := parseRules(filter, true)
+	if err != nil {
+		return nil, err
+	}
+	return *rule, nil
+}
+
+func NewAndRule(rules ...Rule) BlockRule {
+	return BlockRule{rulesAnd: rules}
+}
+
+func NewOrRule(rules ...Rule) BlockRule {
+	return BlockRule{rulesOr: rules}
+}
+
+func NewNotRule(rule Rule) NotRule {
+	return NotRule{rule}
+}
+
+func NewEqualToRule(value string, caseInsensitive bool) EqualToRule {
+	return EqualToRule{value, caseInsensitive}
+}
+
+func NewEqualToBinaryRule(value []byte) EqualToBinaryRule {
+	return EqualToBinaryRule{value}
+}
+
+func NewStartsWithBytesRule(value []byte) StartsWithBytesRule {
+	return StartsWithBytesRule{value}
+}
+
+func NewContainsBytesRule(value []byte) ContainsBytesRule {
+	return ContainsBytesRule{value}
+}
+
+// NewSha256EqualToRule takes the expected digest in hex.
+func NewSha256EqualToRule(digest string) (Sha256EqualToRule, error) {
+	val, err := decodeSha256(digest)
+	if err != nil {
+		return Sha256EqualToRule{}, err
+	}
+	return Sha256EqualToRule{val}, nil
+}
+
+func NewContainsRule(value string, caseInsensitive bool) ContainsRule {
+	return ContainsRule{value, caseInsensitive}
+}
+
+func NewWildcardsRule(pattern string, caseInsensitive bool) WildcardsRule {
+	return WildcardsRule{pattern, caseInsensitive}
+}
+
+func NewRegExRule(pattern string) (RegExRule, error) {
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return RegExRule{}, err
+	}
+	return RegExRule{regex}, nil
+}
+
+func NewAbsentRule() AbsentRule {
+	return AbsentRule{}
+}
+
+// NewDateTimeRule compares dates read with actualFormat (a Go layout, "unix"
+// or "epoch"; RFC 3339 if empty).
+func NewDateTimeRule(before *time.Time, after *time.Time, equalToDateTime *time.Time, actualFormat string) DateTimeRule {
+	if actualFormat == "" {
+		actualFormat = time.RFC3339
+	}
+	return DateTimeRule{before: before, after: after, equalToDateTime: equalToDateTime, timeFormat: actualFormat}
+}
+
+// NewNumberRule compares numbers exactly; tolerance widens equalTo and
+// between for decimals.
+func NewNumberRule(equalTo *Number, greaterThan *Number, lessThan *Number, between *NumberRange, tolerance float64) (NumberRule, error) {
+	rule, err := parseNumberRule(&Filter{EqualToNumber: equalTo, GreaterThan: greaterThan, LessThan: lessThan, Between: between, Tolerance: &tolerance})
+	if err != nil {
+		return NumberRule{}, err
+	}
+	return *rule, nil
+}
+
+func NewEqualToJsonRule(value string, options EqualToBaseRule) (EqualToJsonRule, error) {
+	rule, err := XPathJsonFactory{}.generateEqualsRule(value, &XPathFilterProps{ignoreArrayOrder: options.IgnoreArrayOrder, ignoreExtraElements: options.IgnoreExtraElements})
+	if err != nil {
+		return EqualToJsonRule{}, err
+	}
+	return rule.(EqualToJsonRule), nil
+}
+
+func NewEqualToXmlRule(value string, options EqualToBaseRule, enablePlaceholders bool, exemptedComparisons []string) (EqualToXmlRule, error) {
+	xmlComparisonProps, err := loadXmlComparisonProps(&enablePlaceholders, exemptedComparisons)
+	if err != nil {
+		return EqualToXmlRule{}, err
+	}
+	rule, err := generateEqualToXmlRule(value, &XPathFilterProps{ignoreArrayOrder: options.IgnoreArrayOrder, ignoreExtraElements: options.IgnoreExtraElements}, xmlComparisonProps)
+	if err != nil {
+		return EqualToXmlRule{}, err
+	}
+	return rule.(EqualToXmlRule), nil
+}
+
+// NewMatchesJsonPathRule checks that the path selects a value and, unless
+// innerRule is nil, that innerRule matches it.
+func NewMatchesJsonPathRule(path string, innerRule Rule) (MatchesJsonPathRule, error) {
+	return newMatchesJsonPathRule(path, innerRule)
+}
+
+func newMatchesJsonPathRule(path string, innerRule Rule) (MatchesJsonPathRule, error) {
+	evaluable, err := compileJsonPath(path)
+	if err != nil {
+		return MatchesJsonPathRule{}, err
+	}
+	return MatchesJsonPathRule{path, innerRule, evaluable}, nil
+}
+
+func NewMatchesXPathRule(expression string, namespaces map[string]string, innerRule Rule) (MatchesXmlXPathRule, error) {
+	xPath, err := generateXPath(expression, namespaces)
+	if err != nil {
+		return MatchesXmlXPathRule{}, err
+	}
+	return MatchesXmlXPathRule{xPath, innerRule}, nil
+}
+
+// NewMatchesJsonSchemaRule compiles the schema for the draft of its $schema,
+// or for schemaVersion (2020-12 if empty) if it has none.
+func NewMatchesJsonSchemaRule(schema string, schemaVersion string) (MatchesJsonSchemaRule, error) {
+	var version *string
+	if schemaVersion != "" {
+		version = &schemaVersion
+	}
+	compiled, err := compileJsonSchema(schema, version)
+	if err != nil {
+		return MatchesJsonSchemaRule{}, err
+	}
+	return MatchesJsonSchemaRule{compiled}, nil
+}
+
+func NewMethodRule(methodNames string) (MethodRule, error) {
+	rule, err := parseMethodRule(methodNames)
+	if err != nil {
+		return MethodRule{}, err
+	}
+	return *rule, nil
+}
+
+func NewPathTemplateRule(template string) (PathTemplateRule, error) {
+	pathTemplate, err := ParsePathTemplate(template)
+	if err != nil {
+		return PathTemplateRule{}, err
+	}
+	return PathTemplateRule{pathTemplate}, nil
+}
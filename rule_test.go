@@ -1,19 +1,26 @@
 package wiregock
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
 	"regexp"
+	"strings"
 	"testing"
 	"time"
 )
 
 func TestEqualToRuleCheck(t *testing.T) {
 	ruleCaseSensitive := EqualToRule{"test", false}
-	res, err := ruleCaseSensitive.check("test")
+	res, err := ruleCaseSensitive.Check("test")
 	if err != nil || !res {
 		t.Fatalf(`EqualToRule failed checking: test`)
 	}
 	ruleCaseInsensitive := EqualToRule{"test", true}
-	res, err = ruleCaseInsensitive.check("tEst")
+	res, err = ruleCaseInsensitive.Check("tEst")
 	if err != nil || !res {
 		t.Fatalf(`EqualToRule failed checking: tEst`)
 	}
@@ -21,20 +28,74 @@ func TestEqualToRuleCheck(t *testing.T) {
 
 func TestEqualToBinaryRuleCheck(t *testing.T) {
 	rule := EqualToBinaryRule{[]byte("test")}
-	res, err := rule.check("test")
+	res, err := rule.Check("test")
 	if err != nil || !res {
 		t.Fatalf(`EqualToBinaryRule failed checking: test`)
 	}
 }
 
+func TestBinaryRules(t *testing.T) {
+	pdf := "%PDF-1.7\n\x00\xff\xfe%%EOF"
+	valuesExpected := []struct {
+		filter   string
+		expected bool
+	}{
+		{`{"binaryEqualTo": "JVBERi0xLjcKAP/+JSVFT0Y="}`, true},
+		{`{"binaryEqualTo": "JVBERi0xLjcKAP_-JSVFT0Y"}`, true},
+		{`{"binaryEqualTo": "JVBERi0xLjcK"}`, false},
+		{`{"startsWithBytes": "JVBERi0="}`, true},
+		{`{"startsWithBytes": "iVBORw0KGgo="}`, false},
+		{`{"containsBytes": "AP/+"}`, true},
+		{`{"containsBytes": "AP/9"}`, false},
+		{`{"sha256EqualTo": "3d8e2c3a16ebd7c1f4e0b3bfa1bcd0d1a63c5a0a8e4d6eb1d6ff6ae56e9a3ee0"}`, false},
+	}
+	context := DataContext{BodyBytes: func() []byte { return []byte(pdf) }}
+	for _, valueExpected := range valuesExpected {
+		var filter Filter
+		if err := json.Unmarshal([]byte(valueExpected.filter), &filter); err != nil {
+			t.Fatalf(`Error parsing %s: %s`, valueExpected.filter, err)
+		}
+		rule, err := NewRule(&filter)
+		if err != nil {
+			t.Fatalf(`Error creating rule for %s: %s`, valueExpected.filter, err)
+		}
+		res, err := rule.Check(pdf)
+		if err != nil || res != valueExpected.expected {
+			t.Fatalf(`Wrong %s matching: expected %t, got %t. Error: %s`, valueExpected.filter, valueExpected.expected, res, err)
+		}
+		res, err = checkBody(rule, context.requestBody())
+		if err != nil || res != valueExpected.expected {
+			t.Fatalf(`Wrong %s matching of the body: expected %t, got %t. Error: %s`, valueExpected.filter, valueExpected.expected, res, err)
+		}
+	}
+	sum := sha256.Sum256([]byte(pdf))
+	rule, err := NewSha256EqualToRule(strings.ToUpper(hex.EncodeToString(sum[:])))
+	if err != nil {
+		t.Fatalf(`Error creating Sha256EqualToRule: %s`, err)
+	}
+	if res, err := rule.Check(pdf); err != nil || !res {
+		t.Fatalf(`Sha256EqualToRule failed checking. Error: %s`, err)
+	}
+	for _, filter := range []Filter{
+		{BinaryEqualTo: &[]string{"not base64!"}[0]},
+		{ContainsBytes: &[]string{"A"}[0]},
+		{Sha256EqualTo: &[]string{"abcd"}[0]},
+		{Sha256EqualTo: &[]string{strings.Repeat("x", 64)}[0]},
+	} {
+		if _, err := NewRule(&filter); err == nil {
+			t.Fatalf(`Invalid binary matcher accepted: %s`, describe(filter))
+		}
+	}
+}
+
 func TestNotRuleRuleCheck(t *testing.T) {
 	ruleNotTrue := NotRule{TrueRule{}}
-	res, err := ruleNotTrue.check("test")
+	res, err := ruleNotTrue.Check("test")
 	if err != nil || res {
 		t.Fatalf(`NotRule(TrueRule) failed checking`)
 	}
 	ruleNotFalse := NotRule{FalseRule{}}
-	res, err = ruleNotFalse.check("test")
+	res, err = ruleNotFalse.Check("test")
 	if err != nil || !res {
 		t.Fatalf(`NotRule(FalseRule) failed checking`)
 	}
@@ -42,12 +103,12 @@ func TestNotRuleRuleCheck(t *testing.T) {
 
 func TestContainsRuleCheck(t *testing.T) {
 	ruleCaseSensitive := ContainsRule{"test", false}
-	res, err := ruleCaseSensitive.check("testing")
+	res, err := ruleCaseSensitive.Check("testing")
 	if err != nil || !res {
 		t.Fatalf(`ContainsRule failed checking: test`)
 	}
 	ruleCaseInsensitive := ContainsRule{"test", true}
-	res, err = ruleCaseInsensitive.check("tEsting")
+	res, err = ruleCaseInsensitive.Check("tEsting")
 	if err != nil || !res {
 		t.Fatalf(`ContainsRule failed checking: tEsting`)
 	}
@@ -57,31 +118,97 @@ func TestDateTimeRuleCheck(t *testing.T) {
 	sourceData := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
 
 	ruleEqualToDateTime := DateTimeRule{equalToDateTime: &sourceData, timeFormat: time.RFC3339}
-	res, err := ruleEqualToDateTime.check("2009-11-10T23:00:00Z")
+	res, err := ruleEqualToDateTime.Check("2009-11-10T23:00:00Z")
 	if err != nil || !res {
 		t.Fatalf(`Rule doesn't check that %s is equal %s Error: %s`, "2009-11-10T23:00:00Z", sourceData, err)
 	}
 	ruleBefore := DateTimeRule{before: &sourceData, timeFormat: time.RFC3339}
-	res, err = ruleBefore.check("2009-11-09T23:00:00Z")
+	res, err = ruleBefore.Check("2009-11-09T23:00:00Z")
 	if err != nil || !res {
 		t.Fatalf(`Rule doesn't check that %s is before %s. Error: %s`, "2009-11-09T23:00:00Z", sourceData, err)
 	}
 	ruleAfter := DateTimeRule{after: &sourceData, timeFormat: time.RFC3339}
-	res, err = ruleAfter.check("2009-11-11T23:00:00Z")
+	res, err = ruleAfter.Check("2009-11-11T23:00:00Z")
 	if err != nil || !res {
 		t.Fatalf(`Rule doesn't check that %s is after %s. Error: %s`, "2009-11-11T23:00:00Z", sourceData, err)
 	}
 }
 
+func TestDateTimeRuleRelative(t *testing.T) {
+	now := time.Time{}
+	today := time.Now().UTC()
+	sourceData := time.Date(2009, time.November, 10, 23, 15, 0, 0, time.UTC)
+	valuesExpected := []struct {
+		rule     DateTimeRule
+		value    string
+		expected bool
+	}{
+		{DateTimeRule{before: &now, beforeNow: true, timeFormat: time.RFC3339, beforeOffset: dateTimeOffset{3, "days"}}, today.AddDate(0, 0, 2).Format(time.RFC3339), true},
+		{DateTimeRule{before: &now, beforeNow: true, timeFormat: time.RFC3339, beforeOffset: dateTimeOffset{3, "days"}}, today.AddDate(0, 0, 4).Format(time.RFC3339), false},
+		{DateTimeRule{after: &now, afterNow: true, timeFormat: time.RFC3339, afterOffset: dateTimeOffset{-1, "months"}}, today.AddDate(0, 0, -7).Format(time.RFC3339), true},
+		{DateTimeRule{equalToDateTime: &sourceData, timeFormat: time.RFC3339, truncateExpected: truncateFirstDayOfMonth}, "2009-11-01T00:00:00Z", true},
+		{DateTimeRule{equalToDateTime: &sourceData, timeFormat: time.RFC3339, truncateExpected: truncateLastDayOfMonth}, "2009-11-30T00:00:00Z", true},
+		{DateTimeRule{equalToDateTime: &sourceData, timeFormat: time.RFC3339, truncateExpected: truncateFirstMinuteOfHour, truncateActual: truncateFirstMinuteOfHour}, "2009-11-10T23:59:59Z", true},
+		{DateTimeRule{equalToDateTime: &sourceData, timeFormat: time.RFC3339, truncateActual: truncateFirstHourOfDay}, "2009-11-10T23:15:00Z", false},
+		{DateTimeRule{equalToDateTime: &sourceData, timeFormat: dateTimeFormatUnix}, "1257894900", true},
+		{DateTimeRule{equalToDateTime: &sourceData, timeFormat: dateTimeFormatEpoch}, "1257894900000", true},
+		{DateTimeRule{after: &sourceData, timeFormat: dateTimeFormatEpoch}, "1257894900001", true},
+	}
+	for _, valueExpected := range valuesExpected {
+		res, err := valueExpected.rule.Check(valueExpected.value)
+		if err != nil || res != valueExpected.expected {
+			t.Fatalf(`Wrong DateTimeRule %+v matching of "%s": expected %t, got %t. Error: %s`, valueExpected.rule, valueExpected.value, valueExpected.expected, res, err)
+		}
+	}
+	if res, err := (DateTimeRule{before: &now, timeFormat: time.RFC3339}).Check(today.Format(time.RFC3339)); err != nil || res {
+		t.Fatalf(`Zero time taken as the current one. Error: %s`, err)
+	}
+	if _, err := (DateTimeRule{after: &sourceData, timeFormat: dateTimeFormatUnix}).Check("yesterday"); err == nil {
+		t.Fatalf(`Invalid unix time accepted`)
+	}
+}
+
+func TestDateTimeRuleRange(t *testing.T) {
+	now := time.Now().UTC()
+	valuesExpected := []struct {
+		filter   string
+		value    time.Time
+		expected bool
+	}{
+		{`{"after": "now -1 days", "before": "now"}`, now.Add(-time.Hour), true},
+		{`{"after": "now -1 days", "before": "now"}`, now.Add(time.Hour), false},
+		{`{"after": "now -1 days", "before": "now"}`, now.AddDate(0, 0, -2), false},
+		{`{"after": "now -1 days", "before": "now +1 days"}`, now.Add(12 * time.Hour), true},
+		{`{"after": "now -1 days", "before": "now +1 days"}`, now.AddDate(0, 0, 2), false},
+		{`{"after": "now", "before": "now +2 hours", "expectedOffset": 1, "expectedOffsetUnit": "hours"}`, now.Add(90 * time.Minute), true},
+		{`{"after": "now", "before": "now +2 hours", "expectedOffset": 1, "expectedOffsetUnit": "hours"}`, now.Add(30 * time.Minute), false},
+	}
+	for _, valueExpected := range valuesExpected {
+		var filter Filter
+		if err := json.Unmarshal([]byte(valueExpected.filter), &filter); err != nil {
+			t.Fatalf(`Error parsing %s: %s`, valueExpected.filter, err)
+		}
+		rule, err := NewRule(&filter)
+		if err != nil {
+			t.Fatalf(`Error creating rule for %s: %s`, valueExpected.filter, err)
+		}
+		value := valueExpected.value.Format(time.RFC3339)
+		res, err := rule.Check(value)
+		if err != nil || res != valueExpected.expected {
+			t.Fatalf(`Wrong %s matching of %s: expected %t, got %t. Error: %s`, valueExpected.filter, value, valueExpected.expected, res, err)
+		}
+	}
+}
+
 func TestMatchesJsonPathRule(t *testing.T) {
 	ruleSchema := "$.welcome.message[1]"
-	ruleMatchesJsonPath := MatchesJsonPathRule{ruleSchema, nil}
+	ruleMatchesJsonPath := MatchesJsonPathRule{path: ruleSchema}
 	data := `{
 		"welcome":{
 				"message":["Good Morning", "Hello World!"]
 			}
 		}`
-	res, err := ruleMatchesJsonPath.check(data)
+	res, err := ruleMatchesJsonPath.Check(data)
 	if err != nil || !res {
 		t.Fatalf(`MatchesJsonPathRule failed checking by rule %s: %s. Error: %s"`, ruleSchema, data, err)
 	}
@@ -109,25 +236,122 @@ func TestMatchesJsonSchemaRule(t *testing.T) {
 			}
 		}
 	}`
-	ruleMatchesJsonSchemaRule := MatchesJsonSchemaRule{ruleSchema}
+	schema, err := compileJsonSchema(ruleSchema, nil)
+	if err != nil {
+		t.Fatalf(`MatchesJsonSchemaRule failed compiling %s. Error: %s`, ruleSchema, err)
+	}
+	ruleMatchesJsonSchemaRule := MatchesJsonSchemaRule{schema}
 	data := `{
 		"firstName": "John",
 		"lastName": "Doe",
 		"age": 21
 	}`
-	res, err := ruleMatchesJsonSchemaRule.check(data)
+	res, err := ruleMatchesJsonSchemaRule.Check(data)
 	if err != nil || !res {
 		t.Fatalf(`MatchesJsonSchemaRule failed checking by rule %s: %s. Error: %s`, ruleSchema, data, err)
 	}
 }
 
+func TestMatchesJsonSchemaVersion(t *testing.T) {
+	schema := `{"type": "number", "minimum": 5, "exclusiveMinimum": true}`
+	prefixItems := `{"prefixItems": [{"type": "string"}]}`
+	dependentRequired := `{"dependentRequired": {"a": ["b"]}}`
+	unevaluatedProperties := `{"allOf": [{"properties": {"a": {}}}], "unevaluatedProperties": false}`
+	valuesExpected := []struct {
+		schema   string
+		version  string
+		value    string
+		expected bool
+	}{
+		{schema, "V4", "5", false},
+		{schema, "draft-04", "6", true},
+		{schema, "V4", "a", false},
+		{prefixItems, "V202012", `["a", 1]`, true},
+		{prefixItems, "V202012", `[1]`, false},
+		{prefixItems, "V7", `[1]`, true},
+		{dependentRequired, "2019-09", `{"a": 1, "b": 2}`, true},
+		{dependentRequired, "2019-09", `{"a": 1}`, false},
+		{unevaluatedProperties, "2020-12", `{"a": 1}`, true},
+		{unevaluatedProperties, "2020-12", `{"a": 1, "b": 2}`, false},
+	}
+	for _, valueExpected := range valuesExpected {
+		var filter Filter
+		filter.MatchesJsonSchema = &valueExpected.schema
+		filter.SchemaVersion = &valueExpected.version
+		rules, err := parseRule(&filter)
+		if err != nil {
+			t.Fatalf(`Error parsing schema %s for %s: %s`, valueExpected.schema, valueExpected.version, err)
+		}
+		res, _ := BlockRule{rulesAnd: rules}.Check(valueExpected.value)
+		if res != valueExpected.expected {
+			t.Fatalf(`Wrong %s matching of %s by %s: expected %t, got %t`, valueExpected.version, valueExpected.value, valueExpected.schema, valueExpected.expected, res)
+		}
+	}
+	for _, invalid := range []struct{ schema, version string }{
+		{`{"type": 5}`, "V7"},
+		{`{"type": "string"`, "V7"},
+		{`{"type": "string"}`, "V3"},
+		{`{"type": "number", "minimum": 5, "exclusiveMinimum": true}`, "V7"},
+		{`{"prefixItems": {"type": "string"}}`, "V202012"},
+	} {
+		filter := Filter{MatchesJsonSchema: &invalid.schema, SchemaVersion: &invalid.version}
+		if _, err := parseRule(&filter); err == nil {
+			t.Fatalf(`Invalid schema %s (%s) accepted`, invalid.schema, invalid.version)
+		}
+	}
+}
+
+func TestMatchesJsonSchemaDirectory(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "common"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"address.json":      `{"type": "object", "required": ["city"], "properties": {"zip": {"$ref": "common/zip.json"}}}`,
+		"common/zip.json":   `{"type": "string", "pattern": "^[0-9]{5}$"}`,
+		"common/money.json": `{"$id": "https://example.com/money.json", "type": "number", "minimum": 0}`,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := LoadJsonSchemaDirectory(dir); err != nil {
+		t.Fatalf(`Error loading schema directory: %s`, err)
+	}
+	t.Cleanup(func() {
+		jsonSchemaDirectory.base = ""
+		jsonSchemaDirectory.schemas = nil
+	})
+	schema, err := compileJsonSchema(`{"type": "object", "properties": {"address": {"$ref": "address.json"}, "price": {"$ref": "https://example.com/money.json"}}}`, nil)
+	if err != nil {
+		t.Fatalf(`Error compiling schema with $ref: %s`, err)
+	}
+	rule := MatchesJsonSchemaRule{schema}
+	valuesExpected := map[string]bool{
+		`{"address": {"city": "Oslo", "zip": "01234"}, "price": 1.5}`: true,
+		`{"address": {"zip": "01234"}}`:                               false,
+		`{"address": {"city": "Oslo", "zip": "1"}}`:                   false,
+		`{"price": -1}`: false,
+	}
+	for value, expected := range valuesExpected {
+		res, _ := rule.Check(value)
+		if res != expected {
+			t.Fatalf(`Wrong matching of %s: expected %t, got %t`, value, expected, res)
+		}
+	}
+	if _, err := compileJsonSchema(`{"$ref": "missing.json"}`, nil); err == nil {
+		t.Fatalf(`Missing $ref accepted`)
+	}
+}
+
 func TestAbsentRuleCheck(t *testing.T) {
 	absentRule := AbsentRule{}
-	res, err := absentRule.check("test")
+	res, err := absentRule.Check("test")
 	if err != nil && res {
 		t.Fatalf(`Absent rule catches existing. Error: %s`, err)
 	}
-	res, err = absentRule.check("")
+	res, err = absentRule.Check("")
 	if err != nil && !res {
 		t.Fatalf(`Absent rule doesn't catch non-existing. Error: %s`, err)
 	}
@@ -141,7 +365,7 @@ func TestWildcardsRuleCheck(t *testing.T) {
 
 func checkWildcard(wildcard string, value string, caseInsensitive bool, t *testing.T) {
 	ruleWildcards := WildcardsRule{wildcard, caseInsensitive}
-	res, err := ruleWildcards.check(value)
+	res, err := ruleWildcards.Check(value)
 	if err != nil || !res {
 		t.Fatalf(`WildcardsRule %s failed checking: %s`, wildcard, value)
 	}
@@ -151,31 +375,73 @@ func TestRegExRuleCheck(t *testing.T) {
 	regEx := `00-[a-f\d]{32}-[a-f\d]{16}-01`
 	value := "/00-0af7651916cd43dd8448eb211c80319c-b9c7c989f97918e1-01/"
 	ruleRegEx := RegExRule{regexp.MustCompile(regEx)}
-	res, err := ruleRegEx.check(value)
+	res, err := ruleRegEx.Check(value)
 	if err != nil || !res {
 		t.Fatalf(`RegExRule %s failed checking: %s`, regEx, value)
 	}
 }
 
-/*func TestMatchesJsonXPathRule(t *testing.T) {
+func TestMatchesJsonXPathRule(t *testing.T) {
 	xPathFilterProps := XPathFilterProps{true, true, true}
 	xPathJsonFactory := XPathJsonFactory{}
 	exp := "$.foo"
 	json := `{ "boo": 42 }`
 	xPathFilter := XPathFilter{
-		EqualToJson: &json,
-		Expression:  exp,
+		Filter:     Filter{EqualToJson: &json},
+		Expression: exp,
 	}
 	rule, err := xPathJsonFactory.generateMatchesXPathRule(&xPathFilter, &xPathFilterProps)
 	if err != nil {
 		t.Fatalf(`MatchesJsonXPathRule %s failed with error: %s`, json, err)
 	}
 	value := `{ "foo": { "boo": 42 } }`
-	res, err := rule.check(value)
+	res, err := rule.Check(value)
 	if err != nil || !res {
 		t.Fatalf(`MatchesJsonXPathRule %s failed checking: %s`, json, value)
 	}
-}*/
+}
+
+func TestMatchesJsonPathRuleValues(t *testing.T) {
+	data := `{"id": 42, "price": 1.5, "name": "a<b", "tags": ["x", "y"], "owner": {"name": "bob", "age": 7}, "none": null, "items": [{"id": 1}, {"id": 2}]}`
+	valuesExpected := []struct {
+		filter   string
+		data     string
+		expected bool
+	}{
+		{`{"expression": "$.id"}`, data, true},
+		{`{"expression": "$.missing"}`, data, false},
+		{`{"expression": "$.none"}`, data, false},
+		{`{"expression": "$.id"}`, "not json", false},
+		{`{"expression": "$.id", "equalTo": "42"}`, data, true},
+		{`{"expression": "$.price", "equalTo": "1.5"}`, data, true},
+		{`{"expression": "$.name", "equalTo": "a<b"}`, data, true},
+		{`{"expression": "$.owner", "equalTo": "{\"age\":7,\"name\":\"bob\"}"}`, data, true},
+		{`{"expression": "$.owner", "equalToJson": "{\"name\": \"bob\", \"age\": 7}"}`, data, true},
+		{`{"expression": "$.tags", "equalTo": "[\"x\",\"y\"]"}`, data, true},
+		{`{"expression": "$.tags", "equalTo": "y"}`, data, true},
+		{`{"expression": "$.items[*].id", "equalTo": "2"}`, data, true},
+		{`{"expression": "$.items[?(@.id > 1)]", "equalToJson": "{\"id\": 2}"}`, data, true},
+		{`{"expression": "$.items[?(@.id > 5)]"}`, data, false},
+		{`{"expression": "$.owner.name", "equalTo": "bob"}`, "[1, 2]", false},
+	}
+	for _, valueExpected := range valuesExpected {
+		var xPathFilter XPathFilter
+		if err := json.Unmarshal([]byte(valueExpected.filter), &xPathFilter); err != nil {
+			t.Fatalf(`Error parsing %s: %s`, valueExpected.filter, err)
+		}
+		rule, err := XPathJsonFactory{}.generateMatchesXPathRule(&xPathFilter, &XPathFilterProps{})
+		if err != nil {
+			t.Fatalf(`Error creating rule for %s: %s`, valueExpected.filter, err)
+		}
+		res, err := rule.Check(valueExpected.data)
+		if err != nil || res != valueExpected.expected {
+			t.Fatalf(`Wrong %s matching of %s: expected %t, got %t. Error: %s`, valueExpected.filter, valueExpected.data, valueExpected.expected, res, err)
+		}
+	}
+	if _, err := (XPathJsonFactory{}).generateMatchesXPathRule(&XPathFilter{Expression: "$.[?"}, &XPathFilterProps{}); err == nil {
+		t.Fatalf(`Invalid JSON path accepted`)
+	}
+}
 
 func TestMatchesXmlXPathRule(t *testing.T) {
 	xPathFilterProps := XPathFilterProps{true, true, true}
@@ -183,7 +449,7 @@ func TestMatchesXmlXPathRule(t *testing.T) {
 	exp := "//todo-item"
 	xml := "<todo-item>Do the washing</todo-item>"
 	xPathFilter := XPathFilter{
-		EqualToXml: &xml,
+		Filter:     Filter{EqualToXml: &xml},
 		Expression: exp,
 	}
 	rule, err := xPathXmlFactory.generateMatchesXPathRule(&xPathFilter, &xPathFilterProps)
@@ -191,12 +457,41 @@ func TestMatchesXmlXPathRule(t *testing.T) {
 		t.Fatalf(`MatchesXmlXPathRule %s failed with error: %s`, xml, err)
 	}
 	value := "<foo><todo-item>Do the washing</todo-item></foo>"
-	res, err := rule.check(value)
+	res, err := rule.Check(value)
 	if err != nil || !res {
 		t.Fatalf(`MatchesXmlXPathRule %s failed checking: %s`, xml, value)
 	}
 }
 
+func TestMatchesXPathSubMatchers(t *testing.T) {
+	var filters []Filter
+	err := json.Unmarshal([]byte(`[
+		{"matchesXPath": {"expression": "//id/text()", "matches": "^\\d+$"}},
+		{"matchesXPath": {"expression": "//id/text()", "doesNotMatch": "^0"}},
+		{"matchesXPath": {"expression": "//name/text()", "or": [{"equalTo": "foo"}, {"equalTo": "BAR", "caseInsensitive": true}]}},
+		{"matchesXPath": {"expression": "//created/text()", "and": [{"after": "2020-01-01T00:00:00"}, {"before": "2022-01-01"}]}},
+		{"matchesJsonPath": {"expression": "$.items[*].name", "matches": "^ba"}},
+		{"matchesJsonPath": {"expression": "$.items[*].name", "binaryEqualTo": "Zm9v"}}
+	]`), &filters)
+	if err != nil {
+		t.Fatalf(`Error parsing JSON format: %s`, err)
+	}
+	values := []string{
+		"<item><id>42</id><name>bar</name><created>2021-05-01T00:00:00Z</created></item>",
+		`{"items": [{"name": "foo"}, {"name": "bar"}]}`,
+	}
+	for index, filter := range filters {
+		rule, err := parseRules(&filter, true)
+		if err != nil {
+			t.Fatalf(`Error parsing filter %d: %s`, index, err)
+		}
+		res, err := rule.Check(values[index/4])
+		if err != nil || !res {
+			t.Fatalf(`Filter %d failed checking: %s. Error: %s`, index, values[index/4], err)
+		}
+	}
+}
+
 func TestEqualToJsonRule(t *testing.T) {
 	xPathFilterProps := XPathFilterProps{true, true, true}
 	xPathJsonFactory := XPathJsonFactory{}
@@ -206,12 +501,73 @@ func TestEqualToJsonRule(t *testing.T) {
 		t.Fatalf(`EqualToJsonRule %s failed with error: %s`, json, err)
 	}
 	value := `{"boo": "foo", "foo": "boo"}`
-	res, err := rule.check(value)
+	res, err := rule.Check(value)
 	if err != nil || !res {
 		t.Fatalf(`EqualToJsonRule %s failed checking: %s`, json, value)
 	}
 }
 
+func TestEqualToJsonRuleOptions(t *testing.T) {
+	xPathJsonFactory := XPathJsonFactory{}
+	checkEqualToJson := func(json string, value string, xPathFilterProps XPathFilterProps, expected bool) {
+		rule, err := xPathJsonFactory.generateEqualsRule(json, &xPathFilterProps)
+		if err != nil {
+			t.Fatalf(`EqualToJsonRule %s failed with error: %s`, json, err)
+		}
+		res, err := rule.Check(value)
+		if err != nil || res != expected {
+			t.Fatalf(`EqualToJsonRule %s checking %s: expected %t, got %t. Error: %s`, json, value, expected, res, err)
+		}
+	}
+	strict := XPathFilterProps{}
+	checkEqualToJson(`{"a": 1, "b": [1, 2]}`, `{ "b": [1, 2.0], "a": 1.00 }`, strict, true)
+	checkEqualToJson(`{"a": 1}`, `{"a": "1"}`, strict, false)
+	checkEqualToJson(`{"a": [1, 2]}`, `{"a": [2, 1]}`, strict, false)
+	checkEqualToJson(`{"a": [1, 2]}`, `{"a": [2, 1]}`, XPathFilterProps{ignoreArrayOrder: true}, true)
+	checkEqualToJson(`{"a": 1}`, `{"a": 1, "b": 2}`, strict, false)
+	checkEqualToJson(`{"a": 1}`, `{"a": 1, "b": 2}`, XPathFilterProps{ignoreExtraElements: true}, true)
+	checkEqualToJson(`{"a": [1, 2]}`, `{"a": [1, 2, 3]}`, XPathFilterProps{ignoreExtraElements: true}, true)
+	checkEqualToJson(`[{"id": 1}, {"id": 1, "x": 2}]`, `[{"id": 1, "x": 2}, {"id": 1}]`, XPathFilterProps{ignoreArrayOrder: true, ignoreExtraElements: true}, true)
+	checkEqualToJson(`[1, 1]`, `[1, 2]`, XPathFilterProps{ignoreArrayOrder: true}, false)
+	checkEqualToJson(`null`, `null`, strict, true)
+}
+
+func TestEqualToJsonRulePlaceholders(t *testing.T) {
+	xPathFilterProps := XPathFilterProps{}
+	xPathJsonFactory := XPathJsonFactory{}
+	json := `{
+		"id": "${json-unit.any-string}",
+		"amount": "${json-unit.any-number}",
+		"active": "${json-unit.any-boolean}",
+		"created": "${json-unit.ignore}",
+		"comment": "${json-unit.ignore-element}",
+		"code": "${json-unit.regex}[a-z]+"
+	}`
+	rule, err := xPathJsonFactory.generateEqualsRule(json, &xPathFilterProps)
+	if err != nil {
+		t.Fatalf(`EqualToJsonRule %s failed with error: %s`, json, err)
+	}
+	valuesExpected := map[string]bool{
+		`{"id": "a1", "amount": 12.5, "active": true, "created": [1], "code": "abc"}`:                  true,
+		`{"id": "a1", "amount": 12.5, "active": true, "created": {}, "code": "abc", "comment": "x"}`:   true,
+		`{"id": 1, "amount": 12.5, "active": true, "created": null, "code": "abc"}`:                    false,
+		`{"id": "a1", "amount": "12.5", "active": true, "created": null, "code": "abc"}`:               false,
+		`{"id": "a1", "amount": 12.5, "active": true, "code": "abc"}`:                                  false,
+		`{"id": "a1", "amount": 12.5, "active": true, "created": null, "code": "abc1"}`:                false,
+		`{"id": "a1", "amount": 12.5, "active": true, "created": null, "code": "abc", "extra": false}`: false,
+	}
+	for value, expected := range valuesExpected {
+		res, err := rule.Check(value)
+		if err != nil || res != expected {
+			t.Fatalf(`EqualToJsonRule %s checking %s: expected %t, got %t. Error: %s`, json, value, expected, res, err)
+		}
+	}
+	invalidRegex := `{"code": "${json-unit.regex}[a-z"}`
+	if _, err := xPathJsonFactory.generateEqualsRule(invalidRegex, &xPathFilterProps); err == nil {
+		t.Fatalf(`EqualToJsonRule %s accepted invalid regex placeholder`, invalidRegex)
+	}
+}
+
 func TestEqualToXmlRule(t *testing.T) {
 	xPathFilterProps := XPathFilterProps{true, true, true}
 	xPathXmlFactory := XPathXmlFactory{}
@@ -221,46 +577,215 @@ func TestEqualToXmlRule(t *testing.T) {
 		t.Fatalf(`EqualToXmlRule %s failed with error: %s`, xml, err)
 	}
 	value := "<thing>Hello</thing>"
-	res, err := rule.check(value)
+	res, err := rule.Check(value)
 	if err != nil || !res {
 		t.Fatalf(`EqualToXmlRule %s failed checking: %s`, xml, value)
 	}
 }
 
+func TestEqualToXmlRuleOptions(t *testing.T) {
+	checkEqualToXml := func(xml string, value string, xPathFilterProps XPathFilterProps, xmlComparisonProps XmlComparisonProps, expected bool) {
+		rule, err := generateEqualToXmlRule(xml, &xPathFilterProps, xmlComparisonProps)
+		if err != nil {
+			t.Fatalf(`EqualToXmlRule %s failed with error: %s`, xml, err)
+		}
+		res, err := rule.Check(value)
+		if err != nil || res != expected {
+			t.Fatalf(`EqualToXmlRule %s checking %s: expected %t, got %t. Error: %s`, xml, value, expected, res, err)
+		}
+	}
+	strict := XPathFilterProps{}
+	noProps := XmlComparisonProps{}
+	checkEqualToXml(`<a x="1" y="2"><b>text</b></a>`, "<a y=\"2\" x=\"1\">\n  <!-- comment -->\n  <b> text </b>\n</a>", strict, noProps, true)
+	checkEqualToXml(`<s:a xmlns:s="urn:s"><s:b/></s:a>`, `<t:a xmlns:t="urn:s"><t:b/></t:a>`, strict, noProps, true)
+	checkEqualToXml(`<a xmlns="urn:s"/>`, `<a xmlns="urn:t"/>`, strict, noProps, false)
+	checkEqualToXml(`<a><b/><c/></a>`, `<a><c/><b/></a>`, strict, noProps, false)
+	checkEqualToXml(`<a><b/><c/></a>`, `<a><c/><b/></a>`, XPathFilterProps{ignoreArrayOrder: true}, noProps, true)
+	checkEqualToXml(`<a><b/></a>`, `<a x="1"><d/><b/></a>`, strict, noProps, false)
+	checkEqualToXml(`<a><b/></a>`, `<a x="1"><d/><b/></a>`, XPathFilterProps{ignoreExtraElements: true}, noProps, true)
+
+	enablePlaceholders := true
+	placeholders, err := loadXmlComparisonProps(&enablePlaceholders, []string{"NAMESPACE_URI"})
+	if err != nil {
+		t.Fatalf(`Unable to load XML comparison props: %s`, err)
+	}
+	checkEqualToXml(`<a id="${xmlunit.ignore}"><n>${xmlunit.isNumber}</n><r>${xmlunit.matchesRegex([a-z]+)}</r></a>`, `<a xmlns="urn:x" id="42"><n>1.5</n><r>abc</r></a>`, strict, placeholders, true)
+	checkEqualToXml(`<a><r>${xmlunit.matchesRegex([a-z]+)}</r></a>`, `<a><r>abc1</r></a>`, strict, placeholders, false)
+	checkEqualToXml(`<a><r id="${xmlunit.matchesRegex(\d+)}">${xmlunit.matchesRegex([a-z]+)}</r></a>`, `<a><r id="7">abc</r></a>`, strict, placeholders, true)
+	if _, err := NewEqualToXmlRule(`<a><r>${xmlunit.matchesRegex([)}</r></a>`, EqualToBaseRule{}, true, nil); err == nil {
+		t.Fatalf(`Invalid matchesRegex placeholder accepted`)
+	}
+	if _, err := NewEqualToXmlRule(`<a><r>${xmlunit.matchesRegex([)}</r></a>`, EqualToBaseRule{}, false, nil); err != nil {
+		t.Fatalf(`matchesRegex is compiled without enablePlaceholders: %s`, err)
+	}
+	if _, err := loadXmlComparisonProps(nil, []string{"UNKNOWN"}); err == nil {
+		t.Fatalf(`Unknown exempted comparison accepted`)
+	}
+}
+
+func TestNumberRule(t *testing.T) {
+	ten, twenty, pi := big.NewRat(10, 1), big.NewRat(20, 1), big.NewRat(314, 100)
+	large, _ := new(big.Rat).SetString("9007199254740993")
+	valuesExpected := []struct {
+		rule     NumberRule
+		value    string
+		expected bool
+	}{
+		{NumberRule{greaterThan: ten}, "11", true},
+		{NumberRule{greaterThan: ten}, "10", false},
+		{NumberRule{greaterThan: ten}, "1e2", true},
+		{NumberRule{lessThan: ten}, "-3.5", true},
+		{NumberRule{lessThan: ten}, "10.0", false},
+		{NumberRule{min: ten, max: twenty}, "10", true},
+		{NumberRule{min: ten, max: twenty}, "20", true},
+		{NumberRule{min: ten, max: twenty}, "20.5", false},
+		{NumberRule{max: twenty}, "-100", true},
+		{NumberRule{equalTo: pi}, "3.14", true},
+		{NumberRule{equalTo: pi}, "3.1415", false},
+		{NumberRule{equalTo: pi, tolerance: big.NewRat(1, 100)}, "3.1415", true},
+		{NumberRule{equalTo: large}, "9007199254740993", true},
+		{NumberRule{equalTo: large}, "9007199254740992", false},
+		{NumberRule{greaterThan: large}, "9007199254740994", true},
+		{NumberRule{lessThan: large}, "9007199254740993.5", false},
+		{NumberRule{greaterThan: ten}, " +11 ", true},
+		{NumberRule{greaterThan: ten}, "0x20", false},
+		{NumberRule{greaterThan: ten}, "1_000", false},
+		{NumberRule{greaterThan: ten}, "abc", false},
+		{NumberRule{greaterThan: ten}, "NaN", false},
+		{NumberRule{greaterThan: ten}, "+Inf", false},
+		{NumberRule{greaterThan: ten}, "", false},
+	}
+	for _, valueExpected := range valuesExpected {
+		res, err := valueExpected.rule.Check(valueExpected.value)
+		if err != nil || res != valueExpected.expected {
+			t.Fatalf(`Wrong NumberRule %+v matching of "%s": expected %t, got %t. Error: %s`, valueExpected.rule, valueExpected.value, valueExpected.expected, res, err)
+		}
+	}
+}
+
 func TestBlockRule(t *testing.T) {
 	ruleAndTrueFalse := BlockRule{
 		rulesAnd: []Rule{TrueRule{}, FalseRule{}},
 	}
-	ok, _ := ruleAndTrueFalse.check("")
+	ok, _ := ruleAndTrueFalse.Check("")
 	if ok {
 		t.Fatalf(`ruleAndTrueFalse failed checking`)
 	}
 	ruleAndTrueTrue := BlockRule{
 		rulesAnd: []Rule{TrueRule{}, TrueRule{}},
 	}
-	ok, _ = ruleAndTrueTrue.check("")
+	ok, _ = ruleAndTrueTrue.Check("")
 	if !ok {
 		t.Fatalf(`ruleAndTrueTrue failed checking`)
 	}
 	ruleOrTrueFalse := BlockRule{
 		rulesOr: []Rule{TrueRule{}, FalseRule{}},
 	}
-	ok, _ = ruleOrTrueFalse.check("")
+	ok, _ = ruleOrTrueFalse.Check("")
 	if !ok {
 		t.Fatalf(`ruleOrTrueFalse failed checking`)
 	}
 	ruleOrFalseFalse := BlockRule{
 		rulesOr: []Rule{FalseRule{}, FalseRule{}},
 	}
-	ok, _ = ruleOrFalseFalse.check("")
+	ok, _ = ruleOrFalseFalse.Check("")
 	if ok {
 		t.Fatalf(`ruleOrFalseFalse failed checking`)
 	}
 	ruleEmpty := BlockRule{}
-	ok, _ = ruleEmpty.check("")
+	ok, _ = ruleEmpty.Check("")
 	if !ok {
 		t.Fatalf(`ruleEmpty failed checking`)
 	}
 }
 
 //TODO - UnitTests for MatchesJsonXPathRule, MatchesXmlXPathRule, EqualToJsonRule, EqualToXmlRule
+
+func TestRuleConstructors(t *testing.T) {
+	regEx, err := NewRegExRule(`^\d+$`)
+	if err != nil {
+		t.Fatalf(`Error creating RegExRule: %s`, err)
+	}
+	ten := Number("10")
+	number, err := NewNumberRule(nil, &ten, nil, nil, 0)
+	if err != nil {
+		t.Fatalf(`Error creating NumberRule: %s`, err)
+	}
+	equalToJson, err := NewEqualToJsonRule(`{"id": 1}`, EqualToBaseRule{IgnoreExtraElements: true})
+	if err != nil {
+		t.Fatalf(`Error creating EqualToJsonRule: %s`, err)
+	}
+	jsonPath, err := NewMatchesJsonPathRule("$.count", NewAndRule(regEx, number))
+	if err != nil {
+		t.Fatalf(`Error creating MatchesJsonPathRule: %s`, err)
+	}
+	schema, err := NewMatchesJsonSchemaRule(`{"type": "object", "required": ["id"]}`, "V7")
+	if err != nil {
+		t.Fatalf(`Error creating MatchesJsonSchemaRule: %s`, err)
+	}
+	xPath, err := NewMatchesXPathRule("//a:id/text()", map[string]string{"a": "urn:a"}, NewEqualToRule("1", false))
+	if err != nil {
+		t.Fatalf(`Error creating MatchesXmlXPathRule: %s`, err)
+	}
+	equalToXml, err := NewEqualToXmlRule(`<x:item xmlns:x="urn:a"><x:id>${xmlunit.isNumber}</x:id></x:item>`, EqualToBaseRule{}, true, nil)
+	if err != nil {
+		t.Fatalf(`Error creating EqualToXmlRule: %s`, err)
+	}
+	method, err := NewMethodRule("!DELETE")
+	if err != nil {
+		t.Fatalf(`Error creating MethodRule: %s`, err)
+	}
+	pathTemplate, err := NewPathTemplateRule("/items/{id}")
+	if err != nil {
+		t.Fatalf(`Error creating PathTemplateRule: %s`, err)
+	}
+	date := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	filterRule, err := NewRule(&Filter{Contains: &[]string{"ell"}[0], DoesNotContain: &[]string{"x"}[0]})
+	if err != nil {
+		t.Fatalf(`Error creating rule from Filter: %s`, err)
+	}
+	valuesExpected := []struct {
+		rule     Rule
+		value    string
+		expected bool
+	}{
+		{NewEqualToRule("Hello", true), "hello", true},
+		{NewEqualToBinaryRule([]byte{0, 1}), "\x00\x01", true},
+		{NewStartsWithBytesRule([]byte{0, 1}), "\x00\x01\x02", true},
+		{NewContainsBytesRule([]byte{1, 2}), "\x00\x01\x02", true},
+		{NewContainsRule("ell", false), "Hello", true},
+		{NewWildcardsRule("H*o", false), "Hello", true},
+		{NewNotRule(NewContainsRule("ell", false)), "Hello", false},
+		{NewOrRule(NewEqualToRule("a", false), NewEqualToRule("b", false)), "b", true},
+		{NewAndRule(NewEqualToRule("a", false), NewEqualToRule("b", false)), "b", false},
+		{NewAbsentRule(), "", true},
+		{NewDateTimeRule(&date, nil, nil, ""), "2009-11-09T23:00:00Z", true},
+		{NewDateTimeRule(nil, &date, nil, "epoch"), "1257894000001", true},
+		{jsonPath, `{"count": 11}`, true},
+		{jsonPath, `{"count": 1}`, false},
+		{equalToJson, `{"id": 1, "name": "a"}`, true},
+		{schema, `{"id": 1}`, true},
+		{xPath, `<item xmlns="urn:a"><id>1</id></item>`, true},
+		{equalToXml, `<item xmlns="urn:a"><id>42</id></item>`, true},
+		{method, "GET", true},
+		{method, "DELETE", false},
+		{pathTemplate, "/items/42", true},
+		{filterRule, "Hello", true},
+		{filterRule, "Hellox", false},
+	}
+	for index, valueExpected := range valuesExpected {
+		res, err := valueExpected.rule.Check(valueExpected.value)
+		if err != nil || res != valueExpected.expected {
+			t.Fatalf(`Wrong matching #%d of %s: expected %t, got %t. Error: %s`, index, valueExpected.value, valueExpected.expected, res, err)
+		}
+	}
+	if _, err := NewRegExRule("("); err == nil {
+		t.Fatalf(`Invalid regex accepted`)
+	}
+	if _, err := NewMatchesJsonPathRule("$.[?", nil); err == nil {
+		t.Fatalf(`Invalid JSON path accepted`)
+	}
+	if _, err := NewNumberRule(nil, nil, nil, &NumberRange{}, 0); err == nil {
+		t.Fatalf(`Empty between accepted`)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/url"
 	"regexp"
+	"strings"
 
 	"github.com/google/uuid"
 )
@@ -43,6 +44,43 @@ func CookiesToMap(cookies []*http.Cookie) map[string]string {
 	return resp
 }
 
+func ParsePath(path string) map[string]string {
+	response := map[string]string{}
+	path = strings.Trim(path, "/")
+	if len(path) == 0 {
+		return response
+	}
+	for index, segment := range strings.Split(path, "/") {
+		response[fmt.Sprintf("[%d]", index)] = segment
+	}
+	return response
+}
+
+// PartsToMap parses a multipart body into template data by part name. The
+// first of several parts with the same name wins, and a malformed body has no
+// parts.
+func PartsToMap(contentType string, body string) map[string]RequestData {
+	response := map[string]RequestData{}
+	parts, err := parseMultipart(contentType, body)
+	if err != nil {
+		return response
+	}
+	for _, part := range parts {
+		if _, ok := response[part.Name]; ok {
+			continue
+		}
+		response[part.Name] = RequestData{
+			"name":         part.Name,
+			"fileName":     part.FileName,
+			"headersFull":  part.Headers,
+			"headers":      ToSingleValueMap(part.Headers),
+			"body":         part.Data,
+			"bodyAsBase64": b64.URLEncoding.EncodeToString([]byte(part.Data)),
+		}
+	}
+	return response
+}
+
 func LoadRequestData(req *http.Request) (*RequestData, error) {
 	body, bodyBase64 := "", ""
 	if req.Body != nil {
@@ -53,10 +91,15 @@ func LoadRequestData(req *http.Request) (*RequestData, error) {
 		body = string(b[:])
 		bodyBase64 = b64.URLEncoding.EncodeToString(b)
 	}
+	parts := map[string]RequestData{}
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/") {
+		parts = PartsToMap(req.Header.Get("Content-Type"), body)
+	}
 	return &RequestData{
 		"request": RequestData{
 			"id":           uuid.New().String(),
 			"url":          req.URL.RequestURI(),
+			"path":         ParsePath(req.URL.Path),
 			"queryFull":    req.URL.Query(),
 			"query":        ToSingleValueMap(req.URL.Query()),
 			"method":       req.Method,
@@ -69,10 +112,34 @@ func LoadRequestData(req *http.Request) (*RequestData, error) {
 			"cookies":      CookiesToMap(req.Cookies()),
 			"body":         body,
 			"bodyAsBase64": bodyBase64,
+			"parts":        parts,
 		},
 	}, nil
 }
 
+func LoadStubRequestData(req *http.Request, request *MockRequest) (*RequestData, error) {
+	requestData, err := LoadRequestData(req)
+	if err != nil {
+		return nil, err
+	}
+	if request == nil || request.UrlPathTemplate == nil {
+		return requestData, nil
+	}
+	pathTemplate, err := ParsePathTemplate(*request.UrlPathTemplate)
+	if err != nil {
+		return nil, err
+	}
+	values, ok := pathTemplate.Match(req.URL.Path)
+	if !ok {
+		return requestData, nil
+	}
+	path := (*requestData)["request"].(RequestData)["path"].(map[string]string)
+	for key, value := range values {
+		path[key] = value
+	}
+	return requestData, nil
+}
+
 func LoadFileLinksList(source string) []string {
 	// Ищем все вхождения
 	matches := regExInnerFile.FindAllStringSubmatch(source, -1)
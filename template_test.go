@@ -1,6 +1,8 @@
 package wiregock
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"slices"
 	"strings"
 	"testing"
@@ -35,3 +37,16 @@ func TestUpdateFileLinks(t *testing.T) {
 		t.Fatalf("Not matched template %s in data: %s", source, data)
 	}
 }
+
+func TestLoadStubRequestData(t *testing.T) {
+	urlPathTemplate := "/orders/{orderId}"
+	req := httptest.NewRequest(http.MethodGet, "/orders/42?full=true", nil)
+	requestData, err := LoadStubRequestData(req, &MockRequest{UrlPathTemplate: &urlPathTemplate})
+	if err != nil {
+		t.Fatalf("Error loading request data: %s", err)
+	}
+	path := (*requestData)["request"].(RequestData)["path"].(map[string]string)
+	if path["orderId"] != "42" || path["[0]"] != "orders" || path["[1]"] != "42" {
+		t.Fatalf("Wrong path data: %v", path)
+	}
+}
@@ -0,0 +1,252 @@
+package wiregock
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antchfx/xmlquery"
+)
+
+const xmlSchemaInstanceNamespace = "http://www.w3.org/2001/XMLSchema-instance"
+
+var xmlComparisonTypes = map[string]bool{
+	"ELEMENT_TAG_NAME":             true,
+	"NAMESPACE_URI":                true,
+	"NAMESPACE_PREFIX":             true,
+	"ATTR_VALUE":                   true,
+	"ATTR_NAME_LOOKUP":             true,
+	"ELEMENT_NUM_ATTRIBUTES":       true,
+	"TEXT_VALUE":                   true,
+	"CHILD_NODELIST_LENGTH":        true,
+	"CHILD_NODELIST_SEQUENCE":      true,
+	"SCHEMA_LOCATION":              true,
+	"NO_NAMESPACE_SCHEMA_LOCATION": true,
+}
+
+var regExXmlPlaceholder = regexp.MustCompile(`^\$\{xmlunit\.(ignore|isNumber|isDateTime|matchesRegex\((.*)\))\}$`)
+
+type xmlName struct {
+	namespace string
+	name      string
+}
+
+type xmlElement struct {
+	xmlName
+	attributes map[xmlName]string
+	text       string
+	children   []*xmlElement
+}
+
+type XmlComparisonProps struct {
+	enablePlaceholders  bool
+	exemptedComparisons map[string]bool
+	regexes             map[string]*regexp.Regexp // matchesRegex placeholders by pattern
+}
+
+func loadXmlComparisonProps(enablePlaceholders *bool, exemptedComparisons []string) (XmlComparisonProps, error) {
+	props := XmlComparisonProps{}
+	if enablePlaceholders != nil {
+		props.enablePlaceholders = *enablePlaceholders
+	}
+	if len(exemptedComparisons) > 0 {
+		props.exemptedComparisons = map[string]bool{}
+		for _, comparison := range exemptedComparisons {
+			comparison = strings.ToUpper(strings.TrimSpace(comparison))
+			if !xmlComparisonTypes[comparison] {
+				return props, fmt.Errorf("unsupported exempted XML comparison: %s", comparison)
+			}
+			props.exemptedComparisons[comparison] = true
+		}
+	}
+	return props, nil
+}
+
+// compilePlaceholders compiles the matchesRegex placeholders of the expected
+// element once, failing on an invalid regex.
+func (props *XmlComparisonProps) compilePlaceholders(element *xmlElement) error {
+	if !props.enablePlaceholders {
+		return nil
+	}
+	values := []string{element.text}
+	for _, value := range element.attributes {
+		values = append(values, value)
+	}
+	for _, value := range values {
+		placeholder := regExXmlPlaceholder.FindStringSubmatch(value)
+		if placeholder == nil || !strings.HasPrefix(placeholder[1], "matchesRegex") {
+			continue
+		}
+		if _, ok := props.regexes[placeholder[2]]; ok {
+			continue
+		}
+		regex, err := regexp.Compile("^(?:" + placeholder[2] + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid XML placeholder %s: %w", value, err)
+		}
+		if props.regexes == nil {
+			props.regexes = map[string]*regexp.Regexp{}
+		}
+		props.regexes[placeholder[2]] = regex
+	}
+	for _, child := range element.children {
+		if err := props.compilePlaceholders(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseXml(str string) (*xmlElement, error) {
+	doc, err := xmlquery.Parse(strings.NewReader(str))
+	if err != nil {
+		return nil, err
+	}
+	for node := doc.FirstChild; node != nil; node = node.NextSibling {
+		if node.Type == xmlquery.ElementNode {
+			return loadXmlElement(node), nil
+		}
+	}
+	return nil, fmt.Errorf("no root element in XML document")
+}
+
+func loadXmlElement(node *xmlquery.Node) *xmlElement {
+	element := &xmlElement{
+		xmlName:    xmlName{node.NamespaceURI, node.Data},
+		attributes: map[xmlName]string{},
+	}
+	for _, attr := range node.Attr {
+		if attr.NamespaceURI == "xmlns" || (attr.NamespaceURI == "" && attr.Name.Local == "xmlns") {
+			continue
+		}
+		element.attributes[xmlName{attr.NamespaceURI, attr.Name.Local}] = attr.Value
+	}
+	var text strings.Builder
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		switch child.Type {
+		case xmlquery.ElementNode:
+			element.children = append(element.children, loadXmlElement(child))
+		case xmlquery.TextNode, xmlquery.CharDataNode:
+			text.WriteString(child.Data)
+		}
+	}
+	element.text = strings.TrimSpace(text.String())
+	return element
+}
+
+func (props XmlComparisonProps) exempted(comparison string) bool {
+	return props.exemptedComparisons[comparison]
+}
+
+func equalXml(expected *xmlElement, actual *xmlElement, options EqualToBaseRule, props XmlComparisonProps) bool {
+	if !props.exempted("ELEMENT_TAG_NAME") && expected.name != actual.name {
+		return false
+	}
+	if !props.exempted("NAMESPACE_URI") && expected.namespace != actual.namespace {
+		return false
+	}
+	if !props.exempted("TEXT_VALUE") && !equalXmlValues(expected.text, actual.text, props) {
+		return false
+	}
+	return equalXmlAttributes(expected.attributes, actual.attributes, options, props) &&
+		equalXmlChildren(expected.children, actual.children, options, props)
+}
+
+func equalXmlAttributes(expected map[xmlName]string, actual map[xmlName]string, options EqualToBaseRule, props XmlComparisonProps) bool {
+	expected = props.withoutSchemaLocations(expected)
+	actual = props.withoutSchemaLocations(actual)
+	if !options.IgnoreExtraElements && !props.exempted("ELEMENT_NUM_ATTRIBUTES") && len(expected) != len(actual) {
+		return false
+	}
+	for key, expectedValue := range expected {
+		actualValue, ok := actual[key]
+		if !ok {
+			if props.exempted("ATTR_NAME_LOOKUP") {
+				continue
+			}
+			return false
+		}
+		if !props.exempted("ATTR_VALUE") && !equalXmlValues(expectedValue, actualValue, props) {
+			return false
+		}
+	}
+	return true
+}
+
+func (props XmlComparisonProps) withoutSchemaLocations(attributes map[xmlName]string) map[xmlName]string {
+	schemaLocation := xmlName{xmlSchemaInstanceNamespace, "schemaLocation"}
+	noNamespaceSchemaLocation := xmlName{xmlSchemaInstanceNamespace, "noNamespaceSchemaLocation"}
+	_, hasSchemaLocation := attributes[schemaLocation]
+	_, hasNoNamespaceSchemaLocation := attributes[noNamespaceSchemaLocation]
+	skipSchemaLocation := hasSchemaLocation && props.exempted("SCHEMA_LOCATION")
+	skipNoNamespaceSchemaLocation := hasNoNamespaceSchemaLocation && props.exempted("NO_NAMESPACE_SCHEMA_LOCATION")
+	if !skipSchemaLocation && !skipNoNamespaceSchemaLocation {
+		return attributes
+	}
+	result := make(map[xmlName]string, len(attributes))
+	for key, value := range attributes {
+		if (skipSchemaLocation && key == schemaLocation) || (skipNoNamespaceSchemaLocation && key == noNamespaceSchemaLocation) {
+			continue
+		}
+		result[key] = value
+	}
+	return result
+}
+
+func equalXmlChildren(expected []*xmlElement, actual []*xmlElement, options EqualToBaseRule, props XmlComparisonProps) bool {
+	allowExtra := options.IgnoreExtraElements || props.exempted("CHILD_NODELIST_LENGTH")
+	if allowExtra && len(expected) > len(actual) {
+		return false
+	}
+	if !allowExtra && len(expected) != len(actual) {
+		return false
+	}
+	matches := func(expectedIndex int, actualIndex int) bool {
+		return equalXml(expected[expectedIndex], actual[actualIndex], options, props)
+	}
+	if options.IgnoreArrayOrder || props.exempted("CHILD_NODELIST_SEQUENCE") {
+		return matchAll(len(expected), len(actual), matches)
+	}
+	// Ordered comparison: expected children have to appear in the same order,
+	// extra actual children (when allowed) may be interleaved between them.
+	actualIndex := 0
+	for expectedIndex := range expected {
+		for actualIndex < len(actual) && !matches(expectedIndex, actualIndex) {
+			if !allowExtra {
+				return false
+			}
+			actualIndex++
+		}
+		if actualIndex == len(actual) {
+			return false
+		}
+		actualIndex++
+	}
+	return true
+}
+
+func equalXmlValues(expected string, actual string, props XmlComparisonProps) bool {
+	if props.enablePlaceholders {
+		if placeholder := regExXmlPlaceholder.FindStringSubmatch(expected); placeholder != nil {
+			return props.matchesPlaceholder(placeholder, actual)
+		}
+	}
+	return expected == actual
+}
+
+func (props XmlComparisonProps) matchesPlaceholder(placeholder []string, actual string) bool {
+	switch {
+	case placeholder[1] == "ignore":
+		return true
+	case placeholder[1] == "isNumber":
+		_, err := strconv.ParseFloat(actual, 64)
+		return err == nil
+	case placeholder[1] == "isDateTime":
+		_, err := time.Parse(time.RFC3339, actual)
+		return err == nil
+	}
+	regex, ok := props.regexes[placeholder[2]]
+	return ok && regex.MatchString(actual)
+}